@@ -18,13 +18,15 @@ def getcolor(s):
     random.seed(int(hashlib.sha256(s.encode('utf-8')).hexdigest(), 16) % 10**8)
     return randomcolor.RandomColor().generate()[0]
 
-def plot_data(url,path_to_data):
-    r = requests.get(url)
-    if 'data' not in r.json():
-        raise Exception("problem getting url")
+def plot_data(url,path_to_data,data=None):
+    if data is None:
+        r = requests.get(url)
+        if 'data' not in r.json():
+            raise Exception("problem getting url")
+        data = r.json()['data']
 
     locationSensors = {}
-    for d in r.json()['data']:
+    for d in data:
         if 'l' not in d or d['l'] == '':
             continue
         loc = d['l']
@@ -1,6 +1,8 @@
 import os
 import time
+import base64
 import logging
+import tempfile
 
 from expiringdict import ExpiringDict
 
@@ -33,16 +35,23 @@ def plotdata():
     t = time.time()
 
     payload = request.get_json()
-    if 'url' not in payload:
+    if 'url' not in payload and 'data' not in payload:
         return jsonify({'success': False, 'message': 'must provide callback url'})
     if 'data_folder' not in payload:
-        return jsonify({'success': False, 'message': 'must provide data folder'})
+        # the data was shipped, so the images are shipped back
+        with tempfile.TemporaryDirectory() as data_folder:
+            plot_data(payload.get('url'), data_folder, payload.get('data'))
+            images = {}
+            for fname in os.listdir(data_folder):
+                with open(os.path.join(data_folder, fname), 'rb') as f:
+                    images[os.path.splitext(fname)[0]] = base64.b64encode(f.read()).decode()
+        return jsonify({'success': True, 'message': 'generated data', 'images': images})
 
     try:
         os.makedirs(payload['data_folder'])
     except:
         pass
-    plot_data(payload['url'],payload['data_folder'])
+    plot_data(payload.get('url'), payload['data_folder'], payload.get('data'))
     return jsonify({'success': True, 'message': 'generated data'})
 
 
@@ -87,7 +96,7 @@ def learn():
         return jsonify({'success': False, 'message': 'must provide sensor data'})
     if 'family' not in payload:
         return jsonify({'success': False, 'message': 'must provide family'})
-    if 'csv_file' not in payload:
+    if 'csv_file' not in payload and 'csv_data' not in payload:
         return jsonify({'success': False, 'message': 'must provide CSV file'})
     data_folder = '.'
     if 'data_folder' in payload:
@@ -98,14 +107,28 @@ def learn():
     logger.debug(data_folder)
 
     ai = AI(payload['family'], data_folder)
-    fname = os.path.join(data_folder, payload['csv_file'])
-    try:
-        ai.learn(fname)
-    except FileNotFoundError:
-        return jsonify({"success": False, "message": "could not find '{}'".format(fname)})
+    if 'csv_data' in payload:
+        # the data was shipped instead of shared through the data folder
+        with tempfile.NamedTemporaryFile('w', suffix='.csv', dir=data_folder, delete=False) as f:
+            f.write(payload['csv_data'])
+            fname = f.name
+        try:
+            ai.learn(fname)
+        finally:
+            os.remove(fname)
+    else:
+        fname = os.path.join(data_folder, payload['csv_file'])
+        try:
+            ai.learn(fname)
+        except FileNotFoundError:
+            return jsonify({"success": False, "message": "could not find '{}'".format(fname)})
 
     print(payload['family'])
     ai.save(os.path.join(data_folder, payload['family']) + ".find3.ai")
+    if 'version' in payload:
+        # the version tells the main server which model each AI server has
+        with open(os.path.join(data_folder, payload['family']) + ".find3.version", 'w') as f:
+            f.write(str(payload['version']))
     ai_cache[payload['family']] = ai
     return jsonify({"success": True, "message": "calibrated data"})
 
@@ -113,5 +136,19 @@ def learn():
 def ping():
     return jsonify({'message': 'pong'})
 
+@app.route('/health', methods=['GET'])
+def health():
+    # the versions of the models learned in the working directory
+    versions = {}
+    for fname in os.listdir('.'):
+        if not fname.endswith('.find3.version'):
+            continue
+        try:
+            with open(fname, 'r') as f:
+                versions[fname[:-len('.find3.version')]] = int(f.read())
+        except (IOError, ValueError):
+            pass
+    return jsonify({'success': True, 'message': 'ok', 'models': len(ai_cache), 'versions': versions})
+
 if __name__ == "__main__":
     app.run(host="0.0.0.0", threaded=True)
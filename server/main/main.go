@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"log"
 	"os"
@@ -16,10 +17,11 @@ import (
 	"github.com/schollz/find3/server/main/src/database"
 	"github.com/schollz/find3/server/main/src/mqtt"
 	"github.com/schollz/find3/server/main/src/server"
+	"github.com/schollz/find3/server/main/src/synthetic"
 )
 
 func main() {
-	aiPort := flag.String("ai", "8002", "port for the AI server")
+	aiPort := flag.String("ai", "8002", "port for the AI server, or comma separated host:port of several AI servers")
 	port := flag.String("port", "8003", "port for the data (this) server")
 	debug := flag.Bool("debug", false, "turn on debug mode")
 	mqttServer := flag.String("mqtt-server", "", "add MQTT server")
@@ -27,6 +29,14 @@ func main() {
 	mqttPass := flag.String("mqtt-pass", "1234", "password for mqtt admin")
 	mqttDir := flag.String("mqtt-dir", "mosquitto_config", "location for mqtt admin")
 	dump := flag.String("dump", "", "family database to dump")
+	replay := flag.String("replay", "", "learning data from -dump to calibrate and evaluate offline")
+	replayTest := flag.String("replay-test", "", "data from -dump to classify when replaying (default: newest part of the learning data)")
+	replayFamily := flag.String("replay-family", "replay", "scratch family to calibrate when replaying")
+	replaySplit := flag.Float64("replay-split", api.DefaultReplaySplit, "fraction of the learning data to learn when there is no test data")
+	replayJSON := flag.Bool("json", false, "print the replay report as JSON")
+	synthesize := flag.String("synthetic", "", "floor plan to generate synthetic learning and tracking data from")
+	seed := flag.Int64("seed", synthetic.DefaultOptions.Seed, "seed for the synthetic data")
+	labelTrack := flag.Bool("label-track", false, "add the true location to the synthetic tracking data")
 	memprofile := flag.Bool("memprofile", false, "whether to profile memory")
 	cpuprofile := flag.Bool("cpuprofile", false, "whether to profile cpu")
 	var dataFolder string
@@ -118,6 +128,29 @@ func main() {
 	}
 	if *dump != "" {
 		err = api.Dump(*dump)
+	} else if *synthesize != "" {
+		var learnFile, trackFile string
+		learnFile, trackFile, err = synthetic.GenerateFiles(*synthesize, synthetic.Options{Seed: *seed, LabelTrack: *labelTrack})
+		if err == nil {
+			fmt.Printf("wrote %s and %s\n", learnFile, trackFile)
+		}
+	} else if *replay != "" {
+		var report api.ReplayReport
+		report, err = api.Replay(api.ReplayOptions{
+			Family:    *replayFamily,
+			LearnFile: *replay,
+			TestFile:  *replayTest,
+			Split:     *replaySplit,
+		})
+		if err == nil {
+			if *replayJSON {
+				var b []byte
+				b, err = json.MarshalIndent(report, "", "  ")
+				fmt.Println(string(b))
+			} else {
+				fmt.Print(report)
+			}
+		}
 	} else {
 		err = server.Run(*debug)
 	}
@@ -0,0 +1,427 @@
+// Package aiclient talks to the AI server that learns and classifies fingerprints.
+package aiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// Client learns and classifies fingerprints
+type Client interface {
+	// Classify returns the predictions of the algorithms learned for the family of the sensor data
+	Classify(ctx context.Context, s models.SensorData, dataFolder string) (models.LocationAnalysis, error)
+	// Learn fits the algorithms of the family on a CSV file in the data folder
+	Learn(ctx context.Context, family string, csvFile string, dataFolder string) error
+	// Plot draws the images of the locations from the data at the url
+	Plot(ctx context.Context, url string, dataFolder string) error
+	// Health returns an error if the AI server cannot be used
+	Health(ctx context.Context) error
+}
+
+// ErrCircuitOpen is returned without calling the AI server after too many failures
+var ErrCircuitOpen = errors.New("AI server is unavailable, circuit is open")
+
+// Options configure the HTTP client
+type Options struct {
+	ClassifyTimeout time.Duration
+	LearnTimeout    time.Duration
+	PlotTimeout     time.Duration
+	HealthTimeout   time.Duration
+	// Retries is the number of times a failed call is retried. Learning is
+	// never retried, as it is slow and the next calibration learns again.
+	Retries int
+	// Backoff is the wait before the first retry, which doubles for each retry
+	Backoff time.Duration
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Learning and the other calls have their own circuits.
+	FailureThreshold int
+	// Cooldown is how long the circuit stays open before a call is tried again
+	Cooldown time.Duration
+	// ShipData sends the calibration data and plot data in the requests, for
+	// AI servers that do not share the data folder
+	ShipData bool
+}
+
+// DefaultOptions are used for the options that are left out
+var DefaultOptions = Options{
+	ClassifyTimeout:  10 * time.Second,
+	LearnTimeout:     60 * time.Minute,
+	PlotTimeout:      5 * time.Minute,
+	HealthTimeout:    2 * time.Second,
+	Retries:          2,
+	Backoff:          100 * time.Millisecond,
+	FailureThreshold: 5,
+	Cooldown:         30 * time.Second,
+}
+
+// HTTPClient is the client of the Python AI server
+type HTTPClient struct {
+	url    string
+	opts   Options
+	client *http.Client
+	// breaker is shared by the calls other than learning, so that a slow or
+	// failing calibration does not stop the classifications
+	breaker *Breaker
+	// learnBreaker is used by learning
+	learnBreaker *Breaker
+}
+
+type response struct {
+	Data    models.LocationAnalysis `json:"analysis"`
+	Message string                  `json:"message"`
+	Success bool                    `json:"success"`
+	// Images maps location -> PNG, when the plots are shipped back
+	Images map[string][]byte `json:"images"`
+}
+
+// NewHTTP returns a client of the AI server at the url, like "http://127.0.0.1:8002"
+func NewHTTP(url string, opts Options) *HTTPClient {
+	if opts.ClassifyTimeout <= 0 {
+		opts.ClassifyTimeout = DefaultOptions.ClassifyTimeout
+	}
+	if opts.LearnTimeout <= 0 {
+		opts.LearnTimeout = DefaultOptions.LearnTimeout
+	}
+	if opts.PlotTimeout <= 0 {
+		opts.PlotTimeout = DefaultOptions.PlotTimeout
+	}
+	if opts.HealthTimeout <= 0 {
+		opts.HealthTimeout = DefaultOptions.HealthTimeout
+	}
+	if opts.Retries < 0 {
+		opts.Retries = DefaultOptions.Retries
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultOptions.Backoff
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = DefaultOptions.FailureThreshold
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultOptions.Cooldown
+	}
+	return &HTTPClient{
+		url:  url,
+		opts: opts,
+		client: &http.Client{
+			Transport: &http.Transport{
+				MaxIdleConnsPerHost: 20,
+			},
+		},
+		breaker:      NewBreaker(opts.FailureThreshold, opts.Cooldown),
+		learnBreaker: NewBreaker(opts.FailureThreshold, opts.Cooldown),
+	}
+}
+
+// Breaker returns the circuit breaker of the calls other than learning
+func (h *HTTPClient) Breaker() *Breaker {
+	return h.breaker
+}
+
+// LearnBreaker returns the circuit breaker of learning
+func (h *HTTPClient) LearnBreaker() *Breaker {
+	return h.learnBreaker
+}
+
+// URL returns the address of the AI server
+func (h *HTTPClient) URL() string {
+	return h.url
+}
+
+// dataFolder returns the data folder to send, which is left out when the AI
+// server keeps its own
+func (h *HTTPClient) dataFolder(dataFolder string) string {
+	if h.opts.ShipData {
+		return ""
+	}
+	return dataFolder
+}
+
+// Classify implements Client
+func (h *HTTPClient) Classify(ctx context.Context, s models.SensorData, dataFolder string) (aidata models.LocationAnalysis, err error) {
+	payload := struct {
+		Sensor     models.SensorData `json:"sensor_data"`
+		DataFolder string            `json:"data_folder,omitempty"`
+	}{s, h.dataFolder(dataFolder)}
+	var target response
+	if err = h.call(ctx, "POST", "/classify", payload, &target, h.opts.ClassifyTimeout, h.breaker, h.opts.Retries); err != nil {
+		return
+	}
+	if !target.Success {
+		err = errors.New("unable to analyze: " + target.Message)
+		return
+	}
+	if len(target.Data.Predictions) == 0 {
+		err = errors.New("problem analyzing: no predictions")
+		return
+	}
+	aidata = target.Data
+	return
+}
+
+// Learn implements Client
+func (h *HTTPClient) Learn(ctx context.Context, family string, csvFile string, dataFolder string) error {
+	return h.learn(ctx, family, csvFile, dataFolder, 0)
+}
+
+// learn fits the algorithms of the family, and has the AI server keep the
+// version of the model when it is set
+func (h *HTTPClient) learn(ctx context.Context, family string, csvFile string, dataFolder string, version int64) (err error) {
+	payload := struct {
+		Family     string `json:"family"`
+		CSVFile    string `json:"csv_file,omitempty"`
+		CSVData    string `json:"csv_data,omitempty"`
+		DataFolder string `json:"data_folder,omitempty"`
+		Version    int64  `json:"version,omitempty"`
+	}{Family: family, CSVFile: csvFile, DataFolder: dataFolder, Version: version}
+	if h.opts.ShipData {
+		b, errRead := ioutil.ReadFile(path.Join(dataFolder, csvFile))
+		if errRead != nil {
+			return errors.Wrap(errRead, "problem reading calibration data")
+		}
+		payload.CSVFile, payload.CSVData, payload.DataFolder = "", string(b), ""
+	}
+	var target response
+	if err = h.call(ctx, "POST", "/learn", payload, &target, h.opts.LearnTimeout, h.learnBreaker, 0); err != nil {
+		return
+	}
+	if !target.Success {
+		err = errors.New("failed in AI server: " + target.Message)
+	}
+	return
+}
+
+// Plot implements Client
+func (h *HTTPClient) Plot(ctx context.Context, url string, dataFolder string) (err error) {
+	payload := struct {
+		URL        string          `json:"url,omitempty"`
+		Data       json.RawMessage `json:"data,omitempty"`
+		DataFolder string          `json:"data_folder,omitempty"`
+	}{URL: url, DataFolder: dataFolder}
+	if h.opts.ShipData {
+		// the AI server may not reach the url, so the data is fetched here
+		if payload.Data, err = h.fetchPlotData(ctx, url); err != nil {
+			return
+		}
+		payload.URL, payload.DataFolder = "", ""
+	}
+	var target response
+	if err = h.call(ctx, "POST", "/plot", payload, &target, h.opts.PlotTimeout, h.breaker, h.opts.Retries); err != nil {
+		return
+	}
+	if !target.Success {
+		return errors.New("failed in AI server: " + target.Message)
+	}
+	if !h.opts.ShipData {
+		return
+	}
+	if err = os.MkdirAll(dataFolder, 0755); err != nil {
+		return
+	}
+	for location, img := range target.Images {
+		if err = ioutil.WriteFile(path.Join(dataFolder, location+".png"), img, 0644); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// fetchPlotData gets the data of the family to plot from the url
+func (h *HTTPClient) fetchPlotData(ctx context.Context, url string) (data json.RawMessage, err error) {
+	ctx, cancel := context.WithTimeout(ctx, h.opts.PlotTimeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		err = errors.Wrap(err, "problem getting plot data")
+		return
+	}
+	defer resp.Body.Close()
+	var target struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&target); err != nil {
+		err = errors.Wrap(err, "problem decoding plot data")
+		return
+	}
+	if len(target.Data) == 0 {
+		err = errors.New("problem getting plot data: no data")
+	}
+	data = target.Data
+	return
+}
+
+// Health implements Client
+func (h *HTTPClient) Health(ctx context.Context) (err error) {
+	_, err = h.health(ctx)
+	return
+}
+
+// health checks the AI server, and returns the versions of the models that
+// it learned, by family
+func (h *HTTPClient) health(ctx context.Context) (versions map[string]int64, err error) {
+	var target struct {
+		response
+		Versions map[string]int64 `json:"versions"`
+	}
+	if err = h.call(ctx, "GET", "/health", nil, &target, h.opts.HealthTimeout, h.breaker, h.opts.Retries); err != nil {
+		return
+	}
+	if !target.Success {
+		err = errors.New("AI server is unhealthy: " + target.Message)
+		return
+	}
+	versions = target.Versions
+	return
+}
+
+// call sends the payload and decodes the response into target, retrying
+// failed attempts with backoff while the circuit of the breaker is closed.
+// The calls that the caller cancels do not count as failures.
+func (h *HTTPClient) call(ctx context.Context, method string, route string, payload interface{}, target interface{}, timeout time.Duration, breaker *Breaker, retries int) (err error) {
+	var body []byte
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			return errors.Wrap(err, "problem marshaling data")
+		}
+	}
+	backoff := h.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		if !breaker.Allow() {
+			return ErrCircuitOpen
+		}
+		err = h.do(ctx, method, route, body, target, timeout)
+		if ctx.Err() != nil {
+			breaker.Cancel()
+			return
+		}
+		breaker.Record(err == nil)
+		if err == nil || attempt >= retries {
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (h *HTTPClient) do(ctx context.Context, method string, route string, body []byte, target interface{}, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(method, h.url+route, bytes.NewBuffer(body))
+	if err != nil {
+		return errors.Wrap(err, "problem creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "problem posting payload")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("AI server responded %s", resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return errors.Wrap(err, "problem decoding response")
+	}
+	return
+}
+
+// Breaker is a circuit breaker that opens after a number of consecutive
+// failures, and lets a single call through after the cooldown to test
+// whether it can close again
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	testing   bool
+	now       func() time.Time
+	sync.Mutex
+}
+
+// BreakerState is the state of a circuit breaker
+type BreakerState struct {
+	Open     bool      `json:"open"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+}
+
+// NewBreaker returns a closed circuit breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// Available returns whether a call would be allowed, without letting it through
+func (b *Breaker) Available() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.failures < b.threshold || (!b.testing && b.now().Sub(b.openedAt) >= b.cooldown)
+}
+
+// Allow returns whether a call can be made
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.testing || b.now().Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	// half open, let one call test the AI server
+	b.testing = true
+	return true
+}
+
+// Record records the result of a call
+func (b *Breaker) Record(success bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.testing = false
+	if success {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// Cancel records a call that was canceled by the caller, which says nothing
+// about the AI server
+func (b *Breaker) Cancel() {
+	b.Lock()
+	defer b.Unlock()
+	b.testing = false
+}
+
+// State returns the state of the breaker
+func (b *Breaker) State() (state BreakerState) {
+	b.Lock()
+	defer b.Unlock()
+	state.Failures = b.failures
+	if b.failures >= b.threshold {
+		state.Open = true
+		state.OpenedAt = b.openedAt
+	}
+	return
+}
@@ -0,0 +1,286 @@
+package aiclient
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var fastOptions = Options{
+	Retries:          2,
+	Backoff:          time.Millisecond,
+	FailureThreshold: 3,
+	Cooldown:         50 * time.Millisecond,
+}
+
+func TestHTTPRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"analysis": models.LocationAnalysis{
+				LocationNames: map[string]string{"0": "kitchen"},
+				Predictions:   []models.AlgorithmPrediction{{Name: "SVM", Locations: []string{"0"}, Probabilities: []float64{1}}},
+			},
+		})
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(ts.URL, fastOptions)
+	aidata, err := c.Classify(context.Background(), models.SensorData{Family: "f"}, ".")
+	assert.Nil(t, err)
+	assert.Equal(t, "kitchen", aidata.LocationNames["0"])
+	assert.Equal(t, int32(2), calls)
+	assert.False(t, c.Breaker().State().Open)
+}
+
+func TestHTTPCircuitBreaker(t *testing.T) {
+	var calls int32
+	healthy := int32(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "ok"})
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(ts.URL, fastOptions)
+	assert.NotNil(t, c.Health(context.Background()))
+	assert.Equal(t, int32(3), calls)
+	assert.True(t, c.Breaker().State().Open)
+
+	// the open circuit does not call the AI server
+	assert.Equal(t, ErrCircuitOpen, c.Health(context.Background()))
+	assert.Equal(t, int32(3), calls)
+
+	// after the cooldown one call closes it again
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	assert.Nil(t, c.Health(context.Background()))
+	assert.False(t, c.Breaker().State().Open)
+}
+
+func TestHTTPLearnBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aiclient")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	var learns, classifies int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/learn" {
+			atomic.AddInt32(&learns, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt32(&classifies, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "ok"})
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(ts.URL, fastOptions)
+	// learning is not retried, and fails on its own circuit
+	for i := 0; i < 3; i++ {
+		assert.NotNil(t, c.Learn(context.Background(), "f", "data.csv", dir))
+	}
+	assert.Equal(t, int32(3), learns)
+	assert.True(t, c.LearnBreaker().State().Open)
+	assert.Equal(t, ErrCircuitOpen, c.Learn(context.Background(), "f", "data.csv", dir))
+	assert.False(t, c.Breaker().State().Open)
+	assert.Nil(t, c.Health(context.Background()))
+}
+
+func TestHTTPCanceled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(ts.URL, fastOptions)
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			cancel()
+		}()
+		assert.NotNil(t, c.Health(ctx))
+	}
+	// the canceled calls say nothing about the AI server
+	assert.Equal(t, 0, c.Breaker().State().Failures)
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	opts := fastOptions
+	opts.Retries = 0
+	opts.HealthTimeout = 10 * time.Millisecond
+	start := time.Now()
+	assert.NotNil(t, NewHTTP(ts.URL, opts).Health(context.Background()))
+	assert.True(t, time.Since(start) < 90*time.Millisecond)
+}
+
+func TestFake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aiclient")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	csv := "location,wifi-a,wifi-b\nkitchen,-40.0,-80.0\nkitchen,-42.0,\nliving,-80.0,-40.0\n"
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "data.csv"), []byte(csv), 0644))
+
+	f := NewFake()
+	assert.Nil(t, f.Learn(context.Background(), "family", "data.csv", dir))
+	aidata, err := f.Classify(context.Background(), models.SensorData{
+		Family:  "family",
+		Sensors: map[string]map[string]interface{}{"wifi": {"a": -78.0, "b": -45.0}},
+	}, dir)
+	assert.Nil(t, err)
+	best := aidata.Predictions[0].Locations[0]
+	assert.Equal(t, "living", aidata.LocationNames[best])
+	assert.Equal(t, 1, f.Calls["classify"])
+
+	_, err = f.Classify(context.Background(), models.SensorData{Family: "other"}, dir)
+	assert.NotNil(t, err)
+
+	f.Err = errors.New("down")
+	assert.NotNil(t, f.Health(context.Background()))
+}
+
+func TestPool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aiclient")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "data.csv"), []byte("location,wifi-a\nkitchen,-40.0\n"), 0644))
+
+	var learned, classified [2]int32
+	newWorker := func(i int, healthy bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			switch r.URL.Path {
+			case "/learn":
+				// the data is shipped instead of the shared data folder
+				assert.Equal(t, "location,wifi-a\nkitchen,-40.0\n", payload["csv_data"])
+				assert.Nil(t, payload["data_folder"])
+				atomic.AddInt32(&learned[i], 1)
+			case "/classify":
+				atomic.AddInt32(&classified[i], 1)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": true,
+					"analysis": models.LocationAnalysis{
+						Predictions: []models.AlgorithmPrediction{{Name: "SVM", Locations: []string{"0"}, Probabilities: []float64{1}}},
+					},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "ok"})
+		}))
+	}
+	healthy := newWorker(0, true)
+	defer healthy.Close()
+	down := newWorker(1, false)
+	defer down.Close()
+
+	p := NewPool([]string{healthy.URL, down.URL}, fastOptions)
+	assert.Nil(t, p.Learn(context.Background(), "family", "data.csv", dir))
+	assert.Equal(t, int32(1), learned[0])
+
+	// classifying goes to the worker that learned
+	for i := 0; i < 4; i++ {
+		_, err = p.Classify(context.Background(), models.SensorData{Family: "family"}, dir)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(4), classified[0])
+	assert.Equal(t, int32(0), classified[1])
+
+	// unknown families are spread over the workers, failing over the one that is down
+	for i := 0; i < 4; i++ {
+		_, err = p.Classify(context.Background(), models.SensorData{Family: "other"}, dir)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(8), classified[0])
+	assert.True(t, p.States()[1].Breaker.Open)
+	assert.Nil(t, p.Health(context.Background()))
+}
+
+func TestPoolVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aiclient")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "data.csv"), []byte("location,wifi-a\nkitchen,-40.0\n"), 0644))
+
+	var classified [2]int32
+	var learnedVersion int64
+	newWorker := func(i int, version int64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			switch r.URL.Path {
+			case "/health":
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "ok", "versions": map[string]int64{"family": version}})
+				return
+			case "/learn":
+				if i == 1 {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				atomic.StoreInt64(&learnedVersion, int64(payload["version"].(float64)))
+			case "/classify":
+				atomic.AddInt32(&classified[i], 1)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"success": true,
+					"analysis": models.LocationAnalysis{
+						Predictions: []models.AlgorithmPrediction{{Name: "SVM", Locations: []string{"0"}, Probabilities: []float64{1}}},
+					},
+				})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "message": "ok"})
+		}))
+	}
+	older := newWorker(0, 1)
+	defer older.Close()
+	newer := newWorker(1, 2)
+	defer newer.Close()
+
+	// after a restart, the workers are asked which models they have
+	p := NewPool([]string{older.URL, newer.URL}, fastOptions)
+	for i := 0; i < 4; i++ {
+		_, err = p.Classify(context.Background(), models.SensorData{Family: "family"}, dir)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(0), classified[0])
+	assert.Equal(t, int32(4), classified[1])
+
+	// a worker that missed the latest learning is not used
+	assert.Nil(t, p.Learn(context.Background(), "family", "data.csv", dir))
+	assert.True(t, atomic.LoadInt64(&learnedVersion) > 2)
+	for i := 0; i < 4; i++ {
+		_, err = p.Classify(context.Background(), models.SensorData{Family: "family"}, dir)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int32(4), classified[0])
+	assert.Equal(t, int32(4), classified[1])
+}
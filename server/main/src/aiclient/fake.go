@@ -0,0 +1,164 @@
+package aiclient
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// FakeAlgorithm is the name of the only algorithm of the fake client
+const FakeAlgorithm = "Nearest Centroid"
+
+// Fake is an in-process client for tests. It learns the mean fingerprint of
+// each location from the CSV files, and classifies by the distance to them.
+type Fake struct {
+	// Centroids maps family -> location -> "type-name" -> mean value
+	Centroids map[string]map[string]map[string]float64
+	// Err is returned by every call when it is set
+	Err error
+	// Calls counts the calls of each method
+	Calls map[string]int
+	sync.Mutex
+}
+
+// NewFake returns a fake client that has learned nothing
+func NewFake() *Fake {
+	return &Fake{
+		Centroids: make(map[string]map[string]map[string]float64),
+		Calls:     make(map[string]int),
+	}
+}
+
+func (f *Fake) called(method string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.Calls[method]++
+	return f.Err
+}
+
+// Classify implements Client
+func (f *Fake) Classify(ctx context.Context, s models.SensorData, dataFolder string) (aidata models.LocationAnalysis, err error) {
+	if err = f.called("classify"); err != nil {
+		return
+	}
+	f.Lock()
+	centroids, ok := f.Centroids[s.Family]
+	f.Unlock()
+	if !ok {
+		err = errors.New("unable to analyze: no model for " + s.Family)
+		return
+	}
+
+	fingerprint := make(map[string]float64)
+	for sensorType := range s.Sensors {
+		for name, value := range s.Sensors[sensorType] {
+			if v, ok := value.(float64); ok {
+				fingerprint[fmt.Sprintf("%s-%s", sensorType, name)] = v
+			}
+		}
+	}
+
+	locations := make([]string, 0, len(centroids))
+	for location := range centroids {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	scores := make([]float64, len(locations))
+	total := 0.0
+	for i, location := range locations {
+		d := 0.0
+		for sensor, mean := range centroids[location] {
+			d += math.Pow(fingerprint[sensor]-mean, 2)
+		}
+		scores[i] = 1 / (1 + math.Sqrt(d))
+		total += scores[i]
+	}
+
+	order := make([]int, len(locations))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	aidata.LocationNames = make(map[string]string)
+	prediction := models.AlgorithmPrediction{Name: FakeAlgorithm}
+	for i, location := range locations {
+		aidata.LocationNames[strconv.Itoa(i)] = location
+	}
+	for _, i := range order {
+		prediction.Locations = append(prediction.Locations, strconv.Itoa(i))
+		prediction.Probabilities = append(prediction.Probabilities, math.Round(100*scores[i]/total)/100)
+	}
+	aidata.Predictions = []models.AlgorithmPrediction{prediction}
+	return
+}
+
+// Learn implements Client
+func (f *Fake) Learn(ctx context.Context, family string, csvFile string, dataFolder string) (err error) {
+	if err = f.called("learn"); err != nil {
+		return
+	}
+	file, err := os.Open(path.Join(dataFolder, csvFile))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return
+	}
+	if len(rows) < 2 {
+		return errors.New("failed in AI server: no data")
+	}
+
+	header := rows[0]
+	sums := make(map[string]map[string]float64)
+	counts := make(map[string]int)
+	for _, row := range rows[1:] {
+		location := row[0]
+		if _, ok := sums[location]; !ok {
+			sums[location] = make(map[string]float64)
+		}
+		counts[location]++
+		for j := 1; j < len(row) && j < len(header); j++ {
+			if row[j] == "" {
+				continue
+			}
+			v, errParse := strconv.ParseFloat(row[j], 64)
+			if errParse != nil {
+				return errors.Wrap(errParse, "failed in AI server")
+			}
+			sums[location][header[j]] += v
+		}
+	}
+	centroids := make(map[string]map[string]float64)
+	for location := range sums {
+		centroids[location] = make(map[string]float64)
+		for sensor, sum := range sums[location] {
+			centroids[location][sensor] = sum / float64(counts[location])
+		}
+	}
+
+	f.Lock()
+	f.Centroids[family] = centroids
+	f.Unlock()
+	return
+}
+
+// Plot implements Client
+func (f *Fake) Plot(ctx context.Context, url string, dataFolder string) error {
+	return f.called("plot")
+}
+
+// Health implements Client
+func (f *Fake) Health(ctx context.Context) error {
+	return f.called("health")
+}
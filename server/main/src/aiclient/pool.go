@@ -0,0 +1,248 @@
+package aiclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// Pool spreads the calls over several AI servers. Each call goes to the
+// healthy worker with the fewest calls in flight, and fails over to the next
+// one. Learning is sent to every worker, so that each can classify.
+//
+// Each learning gives the models a new version, which the workers keep. The
+// classifications go to the workers with the newest model of the family,
+// which are found by asking the workers for their versions, like after the
+// main server restarts.
+type Pool struct {
+	workers []*worker
+	// next breaks ties between workers, round robin
+	next int
+	sync.Mutex
+}
+
+type worker struct {
+	client   *HTTPClient
+	inFlight int
+	// versions maps family -> version of the model the worker learned
+	versions map[string]int64
+	// probed is set once the worker said which versions it has
+	probed  bool
+	probing bool
+}
+
+// WorkerState is the state of a worker of a pool
+type WorkerState struct {
+	URL          string       `json:"url"`
+	InFlight     int          `json:"in_flight"`
+	Breaker      BreakerState `json:"breaker"`
+	LearnBreaker BreakerState `json:"learn_breaker"`
+}
+
+// NewPool returns a pool of the AI servers at the urls. The workers do not
+// share the data folder, so the data is shipped in the requests.
+func NewPool(urls []string, opts Options) *Pool {
+	opts.ShipData = true
+	p := new(Pool)
+	for _, url := range urls {
+		p.workers = append(p.workers, &worker{client: NewHTTP(url, opts), versions: make(map[string]int64)})
+	}
+	return p
+}
+
+// current returns the workers with the newest model of the family, or nil
+// if none is known. Call it with p locked.
+func (p *Pool) current(family string) (current map[int]bool) {
+	var newest int64
+	for _, w := range p.workers {
+		if w.versions[family] > newest {
+			newest = w.versions[family]
+		}
+	}
+	if newest == 0 {
+		return
+	}
+	current = make(map[int]bool)
+	for i, w := range p.workers {
+		if w.versions[family] == newest {
+			current[i] = true
+		}
+	}
+	return
+}
+
+// setVersions records the versions of the models of a worker. The versions
+// that were learned in the meantime are newer, so they are kept.
+func (p *Pool) setVersions(i int, versions map[string]int64) {
+	p.Lock()
+	defer p.Unlock()
+	w := p.workers[i]
+	for family, version := range versions {
+		if version > w.versions[family] {
+			w.versions[family] = version
+		}
+	}
+	w.probed = true
+}
+
+// probe asks the available workers that were not asked yet which models
+// they learned
+func (p *Pool) probe(ctx context.Context) {
+	var unprobed []int
+	p.Lock()
+	for i, w := range p.workers {
+		if !w.probed && !w.probing && w.client.Breaker().Available() {
+			w.probing = true
+			unprobed = append(unprobed, i)
+		}
+	}
+	p.Unlock()
+
+	for _, i := range unprobed {
+		versions, err := p.workers[i].client.health(ctx)
+		if err == nil {
+			p.setVersions(i, versions)
+		}
+		p.Lock()
+		p.workers[i].probing = false
+		p.Unlock()
+	}
+}
+
+// States returns the state of each worker
+func (p *Pool) States() (states []WorkerState) {
+	p.Lock()
+	defer p.Unlock()
+	states = make([]WorkerState, len(p.workers))
+	for i, w := range p.workers {
+		states[i] = WorkerState{
+			URL:          w.client.URL(),
+			InFlight:     w.inFlight,
+			Breaker:      w.client.Breaker().State(),
+			LearnBreaker: w.client.LearnBreaker().State(),
+		}
+	}
+	return
+}
+
+// acquire picks the worker for a call, skipping the tried workers, and
+// preferring the workers with the newest model of the family
+func (p *Pool) acquire(family string, tried map[int]bool) (i int, ok bool) {
+	p.Lock()
+	defer p.Unlock()
+	best := p.pick(tried, p.current(family))
+	if best < 0 {
+		best = p.pick(tried, nil)
+	}
+	if best < 0 {
+		return
+	}
+	p.next = (best + 1) % len(p.workers)
+	p.workers[best].inFlight++
+	return best, true
+}
+
+// pick returns the available worker with the fewest calls in flight, among
+// the current workers if there are any, or -1
+func (p *Pool) pick(tried map[int]bool, current map[int]bool) (best int) {
+	best = -1
+	for j := range p.workers {
+		k := (p.next + j) % len(p.workers)
+		w := p.workers[k]
+		if tried[k] || (len(current) > 0 && !current[k]) || !w.client.Breaker().Available() {
+			continue
+		}
+		if best < 0 || w.inFlight < p.workers[best].inFlight {
+			best = k
+		}
+	}
+	return
+}
+
+func (p *Pool) release(i int) {
+	p.Lock()
+	p.workers[i].inFlight--
+	p.Unlock()
+}
+
+// each calls f on one worker after another until it succeeds
+func (p *Pool) each(ctx context.Context, family string, f func(c *HTTPClient) error) (err error) {
+	err = ErrCircuitOpen
+	tried := make(map[int]bool)
+	for len(tried) < len(p.workers) {
+		i, ok := p.acquire(family, tried)
+		if !ok {
+			return
+		}
+		tried[i] = true
+		err = f(p.workers[i].client)
+		p.release(i)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+	}
+	return
+}
+
+// Classify implements Client
+func (p *Pool) Classify(ctx context.Context, s models.SensorData, dataFolder string) (aidata models.LocationAnalysis, err error) {
+	p.probe(ctx)
+	err = p.each(ctx, s.Family, func(c *HTTPClient) (err error) {
+		aidata, err = c.Classify(ctx, s, dataFolder)
+		return
+	})
+	return
+}
+
+// Learn implements Client. It succeeds when at least one worker learned,
+// and the workers that did not keep their older model.
+func (p *Pool) Learn(ctx context.Context, family string, csvFile string, dataFolder string) (err error) {
+	version := time.Now().UnixNano()
+	errs := make([]error, len(p.workers))
+	var wg sync.WaitGroup
+	for i := range p.workers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.workers[i].client.learn(ctx, family, csvFile, dataFolder, version)
+		}(i)
+	}
+	wg.Wait()
+
+	learned := 0
+	for i, errLearn := range errs {
+		if errLearn == nil {
+			learned++
+			p.setVersions(i, map[string]int64{family: version})
+		} else if err == nil {
+			err = errors.Wrap(errLearn, p.workers[i].client.URL())
+		}
+	}
+	if learned > 0 {
+		err = nil
+	}
+	return
+}
+
+// Plot implements Client
+func (p *Pool) Plot(ctx context.Context, url string, dataFolder string) error {
+	return p.each(ctx, "", func(c *HTTPClient) error {
+		return c.Plot(ctx, url, dataFolder)
+	})
+}
+
+// Health implements Client. The pool is healthy when any worker is.
+func (p *Pool) Health(ctx context.Context) (err error) {
+	err = ErrCircuitOpen
+	for i, w := range p.workers {
+		var versions map[string]int64
+		if versions, err = w.client.health(ctx); err == nil {
+			p.setVersions(i, versions)
+			return
+		}
+	}
+	return
+}
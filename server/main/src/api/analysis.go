@@ -1,58 +1,170 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
-	"net/http"
+	"context"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	cache "github.com/robfig/go-cache"
+	"github.com/schollz/find3/server/main/src/aiclient"
 	"github.com/schollz/find3/server/main/src/database"
 	"github.com/schollz/find3/server/main/src/models"
 	"github.com/schollz/find3/server/main/src/utils"
 )
 
-// AIPort designates the port for the AI processing
+// AIPort designates the port for the AI processing. It can also be a comma
+// separated list of ports or host:port addresses, to spread the work over
+// several AI servers.
 var AIPort = "8002"
 var DataFolder = "."
 
-var (
-	httpClient *http.Client
-	routeCache *cache.Cache
-)
+// AIClient learns and classifies with the AI server. It is created for
+// AIPort on first use, unless it is set first, like to a fake in tests.
+var AIClient aiclient.Client
 
-const (
-	MaxIdleConnections int = 20
-	RequestTimeout     int = 3600
-)
+var aiClientOnce sync.Once
 
-// init HTTPClient
-func init() {
-	httpClient = createHTTPClient()
-	routeCache = cache.New(5*time.Minute, 10*time.Minute)
+// getAIClient returns AIClient, creating the client of the AI servers if it is not set
+func getAIClient() aiclient.Client {
+	aiClientOnce.Do(func() {
+		if AIClient != nil {
+			return
+		}
+		urls := aiURLs(AIPort)
+		if len(urls) == 1 && strings.HasPrefix(urls[0], "http://127.0.0.1:") {
+			// a single local AI server shares the data folder
+			AIClient = aiclient.NewHTTP(urls[0], aiclient.DefaultOptions)
+		} else {
+			logger.Log.Infof("using %d AI servers: %s", len(urls), strings.Join(urls, ", "))
+			AIClient = aiclient.NewPool(urls, aiclient.DefaultOptions)
+		}
+	})
+	return AIClient
 }
 
-// createHTTPClient for connection re-use
-func createHTTPClient() *http.Client {
-	client := &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConnsPerHost: MaxIdleConnections,
-		},
-		Timeout: time.Duration(RequestTimeout) * time.Second,
+// aiURLs returns the urls of the AI servers from a comma separated list of
+// ports or host:port addresses
+func aiURLs(addresses string) (urls []string) {
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if !strings.Contains(address, ":") {
+			address = "127.0.0.1:" + address
+		}
+		urls = append(urls, "http://"+address)
 	}
-
-	return client
+	return
 }
 
-type AnalysisResponse struct {
-	Data    models.LocationAnalysis `json:"analysis"`
-	Message string                  `json:"message"`
-	Success bool                    `json:"success"`
+// AIHealth returns whether any AI server can be used, and the state of each
+func AIHealth() (workers []aiclient.WorkerState, err error) {
+	client := getAIClient()
+	ctx, cancel := context.WithTimeout(context.Background(), aiclient.DefaultOptions.HealthTimeout)
+	defer cancel()
+	err = client.Health(ctx)
+	switch c := client.(type) {
+	case *aiclient.HTTPClient:
+		workers = []aiclient.WorkerState{{URL: c.URL(), Breaker: c.Breaker().State(), LearnBreaker: c.LearnBreaker().State()}}
+	case *aiclient.Pool:
+		workers = c.States()
+	}
+	return
 }
 
 func AnalyzeSensorData(s models.SensorData, db *database.Database) (aidata models.LocationAnalysis, err error) {
+	return analyzeWithHierarchy(context.Background(), s, db)
+}
+
+// analyzeWithHierarchy classifies the sensor data, and guesses its location
+// at each level of the hierarchy
+func analyzeWithHierarchy(ctx context.Context, s models.SensorData, db *database.Database) (aidata models.LocationAnalysis, err error) {
+	// correct the signal strengths of the device and use only the sensors that were learned
+	s = prepareSensorData(s, db)
+	aidata, err = analyzePreparedData(ctx, s, db)
+	if err != nil {
+		return
+	}
+	aidata.Hierarchy, err = classifyHierarchy(ctx, withModelFamily(s, db), aidata, db)
+	if err != nil {
+		logger.Log.Warnf("[%s] problem classifying hierarchy: %s", s.Family, err.Error())
+		err = nil
+	}
+	return
+}
+
+// prepareSensorData corrects the signal strengths of the device, keeps only
+// the sensors that were learned and encodes them the way they were learned
+func prepareSensorData(s models.SensorData, db *database.Database) models.SensorData {
+	var offsets map[string]models.DeviceOffset
+	if errGet := db.Get("DeviceOffsets", &offsets); errGet == nil {
+		if offset, ok := offsets[s.Device]; ok {
+			s = offset.Apply(s)
+		}
+	}
+	return GetFeatureSet(db).Apply(s).Encoded()
+}
+
+// withModelFamily names the sensor data after the models the AI learned for
+// its family, which are those of a candidate once it is promoted
+func withModelFamily(s models.SensorData, db *database.Database) models.SensorData {
+	var model string
+	if err := db.Get("ModelFamily", &model); err == nil && model != "" {
+		s.Family = model
+	}
+	return s
+}
+
+// GetAlgorithms returns the AI algorithms whose predictions are combined, or
+// none when all of them are
+func GetAlgorithms(db *database.Database) (algorithms []string) {
+	db.Get("Algorithms", &algorithms)
+	return
+}
+
+// selectAlgorithms returns the predictions of the algorithms
+func selectAlgorithms(predictions []models.AlgorithmPrediction, algorithms []string) (selected []models.AlgorithmPrediction) {
+	// the predictions may be cached, so they are copied
+	selected = []models.AlgorithmPrediction{}
+	for _, prediction := range predictions {
+		for _, algorithm := range algorithms {
+			if prediction.Name == algorithm {
+				selected = append(selected, prediction)
+				break
+			}
+		}
+	}
+	return
+}
+
+// classifyWithAI sends the sensor data to the AI server to be classified by
+// the models learned for the family of the sensor data
+func classifyWithAI(ctx context.Context, s models.SensorData) (aidata models.LocationAnalysis, err error) {
+	// stationary devices send nearly the same fingerprint again and again
+	key := classifications.key(s)
+	if cached, ok := classifications.get(key, s.Family); ok {
+		return cached, nil
+	}
+	aiTime := time.Now()
+	aidata, err = getAIClient().Classify(ctx, s, DataFolder)
+	if err != nil {
+		return
+	}
+	classifications.set(key, s.Family, aidata)
+	logger.Log.Debugf("[%s] python classified %s", s.Family, time.Since(aiTime))
+	return
+}
+
+func analyzeSensorData(ctx context.Context, s models.SensorData, db *database.Database) (aidata models.LocationAnalysis, err error) {
+	// correct the signal strengths of the device and use only the sensors that were learned
+	return analyzePreparedData(ctx, prepareSensorData(s, db), db)
+}
+
+// analyzePreparedData classifies sensor data that was prepared with prepareSensorData
+func analyzePreparedData(ctx context.Context, s models.SensorData, db *database.Database) (aidata models.LocationAnalysis, err error) {
 	startAnalyze := time.Now()
 
 	aidata.Guesses = []models.LocationPrediction{}
@@ -63,53 +175,11 @@ func AnalyzeSensorData(s models.SensorData, db *database.Database) (aidata model
 		err    error
 	}
 	aChan := make(chan a)
-	go func(aChan chan a) {
+	go func(aChan chan a, s models.SensorData) {
 		// inquire the AI
-		aiTime := time.Now()
-		var target AnalysisResponse
-		type ClassifyPayload struct {
-			Sensor     models.SensorData `json:"sensor_data"`
-			DataFolder string            `json:"data_folder"`
-		}
-		var p2 ClassifyPayload
-		p2.Sensor = s
-		p2.DataFolder = DataFolder
-		url := "http://127.0.0.1:" + AIPort + "/classify"
-		bPayload, err := json.Marshal(p2)
-		if err != nil {
-			err = errors.Wrap(err, "problem marshaling data")
-			aChan <- a{err: err}
-			return
-		}
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(bPayload))
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			err = errors.Wrap(err, "problem posting payload")
-			aChan <- a{err: err}
-			return
-		}
-		defer resp.Body.Close()
-
-		err = json.NewDecoder(resp.Body).Decode(&target)
-		if err != nil {
-			err = errors.Wrap(err, "problem decoding response")
-			aChan <- a{err: err}
-			return
-		}
-		if !target.Success {
-			err = errors.New("unable to analyze: " + target.Message)
-			aChan <- a{err: err}
-			return
-		}
-		if len(target.Data.Predictions) == 0 {
-			err = errors.New("problem analyzing: no predictions")
-			aChan <- a{err: err}
-			return
-		}
-		logger.Log.Debugf("[%s] python classified %s", s.Family, time.Since(aiTime))
-		aChan <- a{err: err, aidata: target.Data}
-	}(aChan)
+		aidata, err := classifyWithAI(ctx, s)
+		aChan <- a{err: err, aidata: aidata}
+	}(aChan, withModelFamily(s, db))
 
 	/*
 		type b struct {
@@ -182,9 +252,8 @@ func AnalyzeSensorData(s models.SensorData, db *database.Database) (aidata model
 	// 	logger.Log.Warnf("[%s] nb2 classify: %s", s.Family, cResult.err.Error())
 	// }
 
-	// get efficacy
-	var algorithmEfficacy map[string]map[string]models.BinaryStats
-	db.Get("AlgorithmEfficacy", &algorithmEfficacy)
+	// get the ensemble and the minimum credible probability
+	strategy, minimumProbability := getEnsemble(s.Family, db)
 
 	// get ai results
 	aResult := <-aChan
@@ -194,7 +263,16 @@ func AnalyzeSensorData(s models.SensorData, db *database.Database) (aidata model
 		return
 	}
 	aidata = aResult.aidata
-	aidata.Guesses = determineBestGuess(aidata, algorithmEfficacy)
+	if algorithms := GetAlgorithms(db); len(algorithms) > 0 {
+		aidata.Predictions = selectAlgorithms(aidata.Predictions, algorithms)
+	}
+	aidata.Guesses = strategy.Combine(aidata)
+
+	// a best guess below the calibrated threshold is not credible
+	if minimumProbability > 0 && len(aidata.Guesses) > 0 && aidata.Guesses[0].Probability < minimumProbability {
+		logger.Log.Debugf("[%s] best guess %s (%2.2f) is below minimum probability %2.2f", s.Family, aidata.Guesses[0].Location, aidata.Guesses[0].Probability, minimumProbability)
+		aidata.IsUnknown = true
+	}
 
 	if aidata.IsUnknown {
 		aidata.Guesses = []models.LocationPrediction{
@@ -203,13 +281,44 @@ func AnalyzeSensorData(s models.SensorData, db *database.Database) (aidata model
 				Probability: 1,
 			},
 		}
+	} else {
+		aidata.Position = models.EstimatePosition(aidata.Guesses, GetLocationCoordinates(s.Family, db), PositionGuesses)
 	}
 
 	logger.Log.Debugf("[%s] analyzed in %s", s.Family, time.Since(startAnalyze))
 	return
 }
 
+// getEnsemble returns the ensemble strategy of the family and the minimum credible probability
+func getEnsemble(family string, db *database.Database) (strategy EnsembleStrategy, minimumProbability float64) {
+	var params EnsembleParameters
+	strategyName := DefaultEnsembleStrategy
+	keyValues := make(map[string]interface{})
+	keyValues["AlgorithmEfficacy"] = &params.AlgorithmEfficacy
+	keyValues["BestAlgorithm"] = &params.BestAlgorithm
+	keyValues["StackedModel"] = &params.Stacked
+	keyValues["EnsembleStrategy"] = &strategyName
+	keyValues["MinimumProbability"] = &minimumProbability
+	if errGet := db.GetMany(keyValues); errGet != nil {
+		logger.Log.Warnf("[%s] problem getting efficacy: %s", family, errGet.Error())
+	}
+	strategy, errStrategy := NewEnsembleStrategy(strategyName, params)
+	if errStrategy != nil {
+		logger.Log.Warnf("[%s] using %s ensemble: %s", family, DefaultEnsembleStrategy, errStrategy.Error())
+		strategy, _ = NewEnsembleStrategy(DefaultEnsembleStrategy, params)
+	}
+	return
+}
+
 func determineBestGuess(aidata models.LocationAnalysis, algorithmEfficacy map[string]map[string]models.BinaryStats) (b []models.LocationPrediction) {
+	return weightedGuesses(aidata, func(algorithm, location string) float64 {
+		return algorithmEfficacy[algorithm][location].Informedness
+	})
+}
+
+// weightedGuesses sums the probabilities of the algorithms, each weighted by
+// the algorithm and the guessed location, and normalizes them into guesses
+func weightedGuesses(aidata models.LocationAnalysis, weight func(algorithm, location string) float64) (b []models.LocationPrediction) {
 	// determine consensus
 	locationScores := make(map[string]float64)
 	for _, prediction := range aidata.Predictions {
@@ -224,7 +333,7 @@ func determineBestGuess(aidata models.LocationAnalysis, algorithmEfficacy map[st
 			if len(guessedLocation) == 0 {
 				continue
 			}
-			efficacy := prediction.Probabilities[i] * algorithmEfficacy[prediction.Name][guessedLocation].Informedness
+			efficacy := prediction.Probabilities[i] * weight(prediction.Name, guessedLocation)
 			if _, ok := locationScores[guessedLocation]; !ok {
 				locationScores[guessedLocation] = float64(0)
 			}
@@ -233,7 +342,11 @@ func determineBestGuess(aidata models.LocationAnalysis, algorithmEfficacy map[st
 			}
 		}
 	}
+	return guessesFromScores(locationScores)
+}
 
+// guessesFromScores normalizes the scores of the locations into guesses, best first
+func guessesFromScores(locationScores map[string]float64) (b []models.LocationPrediction) {
 	total := float64(0)
 	for location := range locationScores {
 		total += locationScores[location]
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/schollz/find3/server/main/src/aiclient"
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAIClientRetries(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"analysis": models.LocationAnalysis{
+				LocationNames: map[string]string{"0": "kitchen"},
+				Predictions:   []models.AlgorithmPrediction{{Name: "SVM", Locations: []string{"0"}, Probabilities: []float64{1}}},
+			},
+		})
+	}))
+	defer ts.Close()
+
+	defer func(client aiclient.Client, port string) {
+		AIClient, AIPort = client, port
+		aiClientOnce = sync.Once{}
+	}(AIClient, AIPort)
+	AIClient = nil
+	aiClientOnce = sync.Once{}
+	AIPort = strings.TrimPrefix(ts.URL, "http://")
+
+	// the client of the server retries a failed classification
+	aidata, err := getAIClient().Classify(context.Background(), fingerprint("f", nil), ".")
+	assert.Nil(t, err)
+	assert.Equal(t, "kitchen", aidata.LocationNames["0"])
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
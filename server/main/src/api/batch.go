@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// MaxBatchSize is the most sensor data that can be classified in one batch
+var MaxBatchSize = 1000
+
+// BatchWorkers is the number of sensor data of a batch classified at the same time
+var BatchWorkers = 9
+
+// BatchResult is the classification of one sensor data of a batch
+type BatchResult struct {
+	Timestamp int64                       `json:"timestamp"`
+	Guesses   []models.LocationPrediction `json:"guesses,omitempty"`
+	Position  *models.Position            `json:"position,omitempty"`
+	Hierarchy []models.LevelGuess         `json:"hierarchy,omitempty"`
+	Message   string                      `json:"message,omitempty"`
+	Success   bool                        `json:"success"`
+}
+
+// LocateBatch classifies sensor data at the same time, and returns the
+// results in the same order. The transitions are not constrained and the
+// locations are not smoothed, as the sensor data may be out of order.
+// When store is set, the predictions are saved like those of /locate.
+func LocateBatch(ctx context.Context, datas []models.SensorData, db *database.Database, store bool) (results []BatchResult, err error) {
+	if len(datas) > MaxBatchSize {
+		err = fmt.Errorf("batch has %d sensor data, more than the maximum of %d", len(datas), MaxBatchSize)
+		return
+	}
+	t := time.Now()
+	jobs := make(chan int, len(datas))
+	done := make(chan struct{}, len(datas))
+	results = make([]BatchResult, len(datas))
+	workers := BatchWorkers
+	if workers > len(datas) {
+		workers = len(datas)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range jobs {
+				results[i] = locateOne(ctx, datas[i], db, store)
+				done <- struct{}{}
+			}
+		}()
+	}
+	for i := range datas {
+		jobs <- i
+	}
+	close(jobs)
+	for range datas {
+		<-done
+	}
+	logger.Log.Infof("classified batch of %d in %s", len(datas), time.Since(t))
+	return
+}
+
+func locateOne(ctx context.Context, s models.SensorData, db *database.Database, store bool) (result BatchResult) {
+	// validating adds the timestamp (if missing)
+	err := s.Validate()
+	result.Timestamp = s.Timestamp
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		result.Message = err.Error()
+		return
+	}
+	analysis, err := analyzeWithHierarchy(ctx, s, db)
+	if err != nil {
+		result.Message = err.Error()
+		return
+	}
+	// remove guesses with prob == 0
+	for i, guess := range analysis.Guesses {
+		if guess.Probability == 0 {
+			analysis.Guesses = analysis.Guesses[:i]
+			break
+		}
+	}
+	if store {
+		if err = db.AddPrediction(s.Timestamp, analysis.Guesses); err != nil {
+			logger.Log.Errorf("[%s] problem inserting: %s", s.Family, err.Error())
+		}
+	}
+	result.Guesses = analysis.Guesses
+	result.Position = analysis.Position
+	result.Hierarchy = analysis.Hierarchy
+	result.Success = true
+	return
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocateBatch(t *testing.T) {
+	defer func(size int) { MaxBatchSize = size }(MaxBatchSize)
+	MaxBatchSize = 3
+	wifi := map[string]map[string]interface{}{"wifi": {"a": -40.0}}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		datas []models.SensorData
+		// err is set when the whole batch is rejected
+		err bool
+		// messages are the errors of each sensor data, in order
+		messages []string
+	}{
+		{
+			name:  "too large",
+			ctx:   context.Background(),
+			datas: make([]models.SensorData, 4),
+			err:   true,
+		},
+		{
+			name:     "empty",
+			ctx:      context.Background(),
+			datas:    []models.SensorData{},
+			messages: []string{},
+		},
+		{
+			name: "errors of each sensor data in order",
+			ctx:  context.Background(),
+			datas: []models.SensorData{
+				{Timestamp: 1, Device: "phone", Sensors: wifi},
+				{Timestamp: 2, Family: "f", Sensors: wifi},
+				{Timestamp: 3, Family: "f", Device: "phone"},
+			},
+			messages: []string{"family cannot be empty", "device cannot be empty", "sensor data cannot be empty"},
+		},
+		{
+			name: "canceled",
+			ctx:  canceled,
+			datas: []models.SensorData{
+				{Timestamp: 1, Family: "f", Device: "phone", Sensors: wifi},
+				{Timestamp: 2, Family: "f", Device: "phone", Sensors: wifi},
+			},
+			messages: []string{"context canceled", "context canceled"},
+		},
+	}
+	for _, test := range tests {
+		results, err := LocateBatch(test.ctx, test.datas, nil, false)
+		if test.err {
+			assert.NotNil(t, err, test.name)
+			continue
+		}
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, len(test.messages), len(results), test.name)
+		for i, result := range results {
+			assert.False(t, result.Success, test.name)
+			assert.Equal(t, test.messages[i], result.Message, test.name)
+			assert.Equal(t, test.datas[i].Timestamp, result.Timestamp, test.name)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// BeaconFlushInterval is how often the beacon inventories that changed are saved
+var BeaconFlushInterval = 1 * time.Minute
+
+// RandomizedBeaconMaxAge is how long a beacon with a randomized address is
+// kept after it was last heard, as it will not be heard again once its
+// address changes
+var RandomizedBeaconMaxAge = 24 * time.Hour
+
+type familyBeacons struct {
+	Inventory models.BeaconInventory
+	// Changed is set when the inventory was not saved since it changed
+	Changed bool
+	sync.Mutex
+}
+
+type beaconInventories struct {
+	Families map[string]*familyBeacons
+	sync.Mutex
+}
+
+var globalBeacons beaconInventories
+
+var startBeaconFlush sync.Once
+
+func init() {
+	globalBeacons.Lock()
+	defer globalBeacons.Unlock()
+	globalBeacons.Families = make(map[string]*familyBeacons)
+}
+
+// getFamilyBeacons returns the beacons of the family, which are loaded or
+// computed from all the sensor data when the inventory is nil. Lock it
+// before using the inventory.
+func getFamilyBeacons(family string) *familyBeacons {
+	globalBeacons.Lock()
+	defer globalBeacons.Unlock()
+	f, ok := globalBeacons.Families[family]
+	if !ok {
+		f = new(familyBeacons)
+		globalBeacons.Families[family] = f
+	}
+	return f
+}
+
+// load loads the inventory of the family or computes it from all the sensor
+// data, in which case rebuilt is set. Call it with f locked.
+func (f *familyBeacons) load(family string, db *database.Database) (rebuilt bool, err error) {
+	if f.Inventory != nil {
+		return
+	}
+	var inv models.BeaconInventory
+	if errGet := db.Get("BeaconInventory", &inv); errGet == nil && inv != nil {
+		f.Inventory = inv
+		return
+	}
+	err = f.rebuild(family, db)
+	rebuilt = err == nil
+	return
+}
+
+// rebuild computes the inventory again from all the sensor data and saves
+// it. Call it with f locked.
+func (f *familyBeacons) rebuild(family string, db *database.Database) (err error) {
+	datas, err := db.GetAllFingerprints()
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("[%s] computing beacon inventory from %d fingerprints", family, len(datas))
+	inv := make(models.BeaconInventory)
+	for _, data := range datas {
+		inv.Add(data)
+	}
+	inv.Prune(beaconPruneTime())
+	if err = db.Set("BeaconInventory", inv); err != nil {
+		return
+	}
+	f.Inventory = inv
+	f.Changed = false
+	return
+}
+
+// beaconPruneTime is the time, in milliseconds, before which randomized beacons are removed
+func beaconPruneTime() int64 {
+	return time.Now().Add(-RandomizedBeaconMaxAge).UnixNano() / int64(time.Millisecond)
+}
+
+// UpdateBeacons adds the sensors of stored sensor data to the beacon
+// inventory of its family. The inventory is kept in memory and saved
+// every BeaconFlushInterval.
+func UpdateBeacons(s models.SensorData) {
+	startBeaconFlush.Do(func() {
+		go func() {
+			for {
+				time.Sleep(BeaconFlushInterval)
+				FlushBeacons()
+			}
+		}()
+	})
+
+	f := getFamilyBeacons(s.Family)
+	f.Lock()
+	defer f.Unlock()
+	if f.Inventory == nil {
+		db, err := database.Open(s.Family)
+		if err != nil {
+			logger.Log.Warn(err)
+			return
+		}
+		defer db.Close()
+		rebuilt, err := f.load(s.Family, db)
+		if err != nil {
+			logger.Log.Warnf("[%s] problem getting beacons: %s", s.Family, err.Error())
+			return
+		}
+		if rebuilt {
+			// the sensor data was stored, so it is already counted
+			return
+		}
+	}
+	f.Inventory.Add(s)
+	f.Changed = true
+}
+
+// FlushBeacons removes the randomized beacons that were not heard for
+// RandomizedBeaconMaxAge, and saves the beacon inventories that changed
+func FlushBeacons() {
+	globalBeacons.Lock()
+	families := make(map[string]*familyBeacons, len(globalBeacons.Families))
+	for family, f := range globalBeacons.Families {
+		families[family] = f
+	}
+	globalBeacons.Unlock()
+
+	for family, f := range families {
+		f.Lock()
+		if !f.Changed || f.Inventory == nil {
+			f.Unlock()
+			continue
+		}
+		f.Inventory.Prune(beaconPruneTime())
+		err := func() (err error) {
+			db, err := database.Open(family)
+			if err != nil {
+				return
+			}
+			defer db.Close()
+			return db.Set("BeaconInventory", f.Inventory)
+		}()
+		if err == nil {
+			f.Changed = false
+		} else {
+			logger.Log.Warnf("[%s] problem saving beacons: %s", family, err.Error())
+		}
+		f.Unlock()
+	}
+}
+
+// GetBeacons returns the beacons heard by the family of a sensor type, or
+// of all types when it is empty. With rebuild, the inventory is computed
+// again from the sensor data, like after deleting data.
+func GetBeacons(family string, sensorType string, rebuild bool, db *database.Database) (inv models.BeaconInventory, err error) {
+	f := getFamilyBeacons(family)
+	f.Lock()
+	defer f.Unlock()
+	if rebuild {
+		err = f.rebuild(family, db)
+	} else {
+		_, err = f.load(family, db)
+	}
+	if err != nil {
+		return
+	}
+	// copy, as the inventory keeps changing
+	filtered := f.Inventory.Filter(sensorType)
+	inv = make(models.BeaconInventory, len(filtered))
+	for key, b := range filtered {
+		copied := *b
+		copied.Locations = make(map[string]*models.RSSIStats, len(b.Locations))
+		for location, stats := range b.Locations {
+			statsCopy := *stats
+			copied.Locations[location] = &statsCopy
+		}
+		inv[key] = &copied
+	}
+	return
+}
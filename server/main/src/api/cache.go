@@ -0,0 +1,206 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// ClassificationCacheBytes bounds the memory used by the cached classifications
+var ClassificationCacheBytes = 16 * 1024 * 1024
+
+// ClassificationCacheTTL is how long a classification is cached
+var ClassificationCacheTTL = 5 * time.Minute
+
+// ClassificationCacheBucket is the width in dB of the buckets the signal
+// strengths are rounded to, so nearly identical fingerprints share a classification
+var ClassificationCacheBucket = 3.0
+
+// CacheStats are the hits and misses of the classification cache
+type CacheStats struct {
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+	Entries int     `json:"entries,omitempty"`
+	Bytes   int     `json:"bytes,omitempty"`
+}
+
+// classificationCache keeps the classifications of the AI server, least
+// recently used first out, keyed by family, model version and fingerprint
+type classificationCache struct {
+	order   *list.List
+	entries map[string]*list.Element
+	bytes   int
+	// versions maps family -> version of its models, bumped by each calibration
+	versions map[string]int
+	// stats maps family -> hits and misses
+	stats map[string]*CacheStats
+	sync.Mutex
+}
+
+type cacheEntry struct {
+	key     string
+	family  string
+	aidata  models.LocationAnalysis
+	size    int
+	expires time.Time
+}
+
+var classifications *classificationCache
+
+func init() {
+	classifications = newClassificationCache()
+}
+
+func newClassificationCache() *classificationCache {
+	return &classificationCache{
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		versions: make(map[string]int),
+		stats:    make(map[string]*CacheStats),
+	}
+}
+
+// key returns the cache key of the sensor data for the current models of its family
+func (c *classificationCache) key(s models.SensorData) string {
+	c.Lock()
+	version := c.versions[s.Family]
+	c.Unlock()
+	return fmt.Sprintf("%s/%d/%x", s.Family, version, fingerprintHash(s, ClassificationCacheBucket))
+}
+
+// get returns the cached classification
+func (c *classificationCache) get(key, family string) (aidata models.LocationAnalysis, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+	stats := c.familyStats(family)
+	if e, found := c.entries[key]; found {
+		entry := e.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(e)
+			stats.Hits++
+			return entry.aidata, true
+		}
+		c.remove(e)
+	}
+	stats.Misses++
+	return
+}
+
+// set caches a classification, evicting the least recently used ones to stay in memory
+func (c *classificationCache) set(key, family string, aidata models.LocationAnalysis) {
+	c.Lock()
+	defer c.Unlock()
+	if e, found := c.entries[key]; found {
+		c.remove(e)
+	}
+	entry := &cacheEntry{
+		key:     key,
+		family:  family,
+		aidata:  aidata,
+		size:    len(key) + analysisSize(aidata),
+		expires: time.Now().Add(ClassificationCacheTTL),
+	}
+	if entry.size > ClassificationCacheBytes {
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	c.bytes += entry.size
+	for c.bytes > ClassificationCacheBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+// invalidate forgets the classifications of a family, after its models change
+func (c *classificationCache) invalidate(family string) {
+	c.Lock()
+	defer c.Unlock()
+	c.versions[family]++
+	for e := c.order.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*cacheEntry).family == family {
+			c.remove(e)
+		}
+		e = next
+	}
+}
+
+func (c *classificationCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *classificationCache) familyStats(family string) *CacheStats {
+	if _, ok := c.stats[family]; !ok {
+		c.stats[family] = new(CacheStats)
+	}
+	return c.stats[family]
+}
+
+// GetCacheStats returns the hits and misses of the classification cache for
+// a family, or of all families and the memory used when the family is empty
+func GetCacheStats(family string) (stats CacheStats) {
+	c := classifications
+	c.Lock()
+	defer c.Unlock()
+	if family != "" {
+		if s, ok := c.stats[family]; ok {
+			stats = *s
+		}
+	} else {
+		for _, s := range c.stats {
+			stats.Hits += s.Hits
+			stats.Misses += s.Misses
+		}
+		stats.Entries = len(c.entries)
+		stats.Bytes = c.bytes
+	}
+	if stats.Hits+stats.Misses > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+	}
+	return
+}
+
+// fingerprintHash hashes the sensors of the sensor data, with the signal
+// strengths rounded to buckets
+func fingerprintHash(s models.SensorData, bucket float64) uint64 {
+	sensors := make([]string, 0, 32)
+	for sensorType := range s.Sensors {
+		for name, value := range s.Sensors[sensorType] {
+			if v, ok := value.(float64); ok && bucket > 0 && models.RSSISensorTypes[sensorType] {
+				value = math.Round(v / bucket)
+			}
+			sensors = append(sensors, fmt.Sprintf("%s-%s=%v", sensorType, name, value))
+		}
+	}
+	sort.Strings(sensors)
+	h := fnv.New64a()
+	for _, sensor := range sensors {
+		h.Write([]byte(sensor))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// analysisSize estimates the bytes used by a classification
+func analysisSize(aidata models.LocationAnalysis) (size int) {
+	size = 256
+	for key, name := range aidata.LocationNames {
+		size += len(key) + len(name) + 32
+	}
+	for _, prediction := range aidata.Predictions {
+		size += len(prediction.Name) + 64
+		for _, location := range prediction.Locations {
+			size += len(location) + 16
+		}
+		size += 8 * len(prediction.Probabilities)
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/schollz/find3/server/main/src/aiclient"
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func fingerprint(family string, sensors map[string]map[string]interface{}) models.SensorData {
+	return models.SensorData{Family: family, Device: "phone", Sensors: sensors}
+}
+
+func TestFingerprintHash(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]map[string]interface{}
+		same bool
+	}{
+		{"same bucket", map[string]map[string]interface{}{"wifi": {"a": -41.0}}, map[string]map[string]interface{}{"wifi": {"a": -42.0}}, true},
+		{"other bucket", map[string]map[string]interface{}{"wifi": {"a": -40.0}}, map[string]map[string]interface{}{"wifi": {"a": -50.0}}, false},
+		{"other sensor", map[string]map[string]interface{}{"wifi": {"a": -40.0}}, map[string]map[string]interface{}{"wifi": {"b": -40.0}}, false},
+		{"numbers are not bucketed", map[string]map[string]interface{}{"temperature": {"t": 21.0}}, map[string]map[string]interface{}{"temperature": {"t": 22.0}}, false},
+		{"categories", map[string]map[string]interface{}{"door": {"front": "open"}}, map[string]map[string]interface{}{"door": {"front": "closed"}}, false},
+		{"order", map[string]map[string]interface{}{"wifi": {"a": -40.0, "b": -60.0}, "bluetooth": {"c": -70.0}}, map[string]map[string]interface{}{"bluetooth": {"c": -70.0}, "wifi": {"b": -60.0, "a": -40.0}}, true},
+	}
+	for _, test := range tests {
+		a := fingerprintHash(fingerprint("f", test.a), 3)
+		b := fingerprintHash(fingerprint("f", test.b), 3)
+		assert.Equal(t, test.same, a == b, test.name)
+	}
+}
+
+func TestClassificationCache(t *testing.T) {
+	defer func(bytes int, ttl time.Duration) {
+		ClassificationCacheBytes, ClassificationCacheTTL = bytes, ttl
+	}(ClassificationCacheBytes, ClassificationCacheTTL)
+	aidata := models.LocationAnalysis{LocationNames: map[string]string{"0": "kitchen"}}
+
+	tests := []struct {
+		name string
+		// bound is the number of entries that fit in the cache
+		bound int
+		ttl   time.Duration
+		run   func(c *classificationCache, keys []string)
+		// cached are the keys that are expected to be in the cache
+		cached []bool
+	}{
+		{
+			name: "least recently used is evicted", bound: 2, ttl: time.Minute,
+			run: func(c *classificationCache, keys []string) {
+				c.set(keys[0], "f", aidata)
+				c.set(keys[1], "f", aidata)
+				c.get(keys[0], "f")
+				c.set(keys[2], "f", aidata)
+			},
+			cached: []bool{true, false, true},
+		},
+		{
+			name: "entries expire", bound: 3, ttl: time.Millisecond,
+			run: func(c *classificationCache, keys []string) {
+				c.set(keys[0], "f", aidata)
+				time.Sleep(2 * time.Millisecond)
+			},
+			cached: []bool{false, false, false},
+		},
+		{
+			name: "invalidating forgets the family", bound: 3, ttl: time.Minute,
+			run: func(c *classificationCache, keys []string) {
+				c.set(keys[0], "f", aidata)
+				c.set(keys[1], "g", aidata)
+				c.invalidate("f")
+			},
+			cached: []bool{false, true, false},
+		},
+	}
+	for _, test := range tests {
+		c := newClassificationCache()
+		keys := []string{"f/0/1", "g/0/2", "f/0/3"}
+		ClassificationCacheBytes = test.bound * (len(keys[0]) + analysisSize(aidata))
+		ClassificationCacheTTL = test.ttl
+		test.run(c, keys)
+		for i, key := range keys {
+			_, ok := c.get(key, "f")
+			assert.Equal(t, test.cached[i], ok, "%s: %s", test.name, key)
+		}
+		assert.True(t, c.bytes <= ClassificationCacheBytes, test.name)
+	}
+
+	// each calibration gives new keys
+	c := newClassificationCache()
+	s := fingerprint("f", map[string]map[string]interface{}{"wifi": {"a": -40.0}})
+	key := c.key(s)
+	c.invalidate("f")
+	assert.NotEqual(t, key, c.key(s))
+	assert.Equal(t, c.key(s), c.key(s))
+}
+
+func TestClassifyWithAICache(t *testing.T) {
+	defer func(client aiclient.Client) { AIClient = client }(AIClient)
+	fake := aiclient.NewFake()
+	fake.Centroids["cachefamily"] = map[string]map[string]float64{
+		"kitchen": {"wifi-a": -40},
+		"living":  {"wifi-a": -80},
+	}
+	AIClient = fake
+	defer classifications.invalidate("cachefamily")
+
+	tests := []struct {
+		name  string
+		rssi  float64
+		calls int
+	}{
+		{"first fingerprint is classified", -41, 1},
+		{"same fingerprint is cached", -41, 1},
+		{"same bucket is cached", -42, 1},
+		{"other bucket is classified", -70, 2},
+	}
+	for _, test := range tests {
+		aidata, err := classifyWithAI(context.Background(), fingerprint("cachefamily", map[string]map[string]interface{}{"wifi": {"a": test.rssi}}))
+		assert.Nil(t, err, test.name)
+		assert.NotEmpty(t, aidata.Predictions, test.name)
+		assert.Equal(t, test.calls, fake.Calls["classify"], test.name)
+	}
+
+	// a calibration invalidates the cached classifications
+	classifications.invalidate("cachefamily")
+	_, err := classifyWithAI(context.Background(), fingerprint("cachefamily", map[string]map[string]interface{}{"wifi": {"a": -41.0}}))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, fake.Calls["classify"])
+}
@@ -1,46 +1,80 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"math"
 	"math/rand"
-	"net/http"
 	"os"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/schollz/find3/server/main/src/database"
-	//"github.com/schollz/find3/server/main/src/learning/nb1"
+	"github.com/schollz/find3/server/main/src/learning/nb1"
 	//"github.com/schollz/find3/server/main/src/learning/nb2"
 	"github.com/schollz/find3/server/main/src/models"
 	"github.com/schollz/find3/server/main/src/utils"
 )
 
-// Calibrate will send the sensor data for a specific family to the machine learning algorithms
-func Calibrate(family string, db *database.Database, crossValidation ...bool) (err error) {
+// fitFamily will fit the machine learning algorithms on the learning data of the family,
+// and return the data that was set aside for cross validation
+func fitFamily(ctx context.Context, family string, db *database.Database, job *calibrationJob, crossValidation ...bool) (datasTest []models.SensorData, err error) {
 	// gather the data
+	job.setPhase("export")
 	datas, err := db.GetAllForClassification()
 	if err != nil {
 		return
 	}
+	return fitDatas(ctx, family, datas, db, job, crossValidation...)
+}
 
+// fitDatas will fit the machine learning algorithms of the family on the
+// learning data, and return the data that was set aside for cross validation
+func fitDatas(ctx context.Context, family string, datas []models.SensorData, db *database.Database, job *calibrationJob, crossValidation ...bool) (datasTest []models.SensorData, err error) {
+	// check the data before learning from it
+	report := lintData(datas, db)
+	for _, issue := range report.Issues {
+		logger.Log.Debugf("[%s] lint %s %s %s: %s", family, issue.Severity, issue.Check, issue.Location, issue.Message)
+	}
+	if report.HasErrors() && GetCalibrationPolicy(db).StrictLint {
+		err = fmt.Errorf("learning data has %d errors, see the lint report", report.Errors)
+		return
+	}
+
+	// set aside the data for cross validation first, so that nothing is
+	// fitted on the data it is tested with
 	datasLearn, datasTest, err := splitDataForLearning(datas, crossValidation...)
 	if err != nil {
 		return
 	}
-	/*
-		// do the Golang naive bayes fitting
-		nb := nb1.New()
-		logger.Log.Debugf("naive bayes1 fitting")
-		errFit := nb.Fit(datasLearn)
-		if errFit != nil {
-			logger.Log.Error(errFit)
-		}
+	job.setSizes(len(datasLearn), len(datasTest))
+
+	// correct the devices that report different signal strengths
+	offsets := estimateDeviceOffsets(family, datasLearn, db)
+
+	// remember the environment, to notice when it changes
+	baseline := models.NewDriftBaseline(applyOffsets(datasLearn, offsets))
+
+	// drop the sensors that only add noise
+	features := selectFeatures(family, datasLearn, db)
+	for i := range datasLearn {
+		datasLearn[i] = features.Apply(datasLearn[i])
+	}
+	for i := range datasTest {
+		datasTest[i] = features.Apply(datasTest[i])
+	}
+	job.setFeatures(features)
+	// do the Golang naive bayes fitting, which explains the guesses
+	nb := nb1.New()
+	logger.Log.Debugf("naive bayes1 fitting")
+	errNB := nb.Learn(applyOffsets(datasLearn, offsets))
+	if errNB != nil {
+		logger.Log.Warnf("[%s] problem fitting naive bayes1: %s", family, errNB.Error())
+	}
 
+	/*
 		// do the Golang naive bayes2 fitting
 		nbFit2 := nb2.New()
 		logger.Log.Debugf("naive bayes2 fitting")
@@ -51,12 +85,33 @@ func Calibrate(family string, db *database.Database, crossValidation ...bool) (e
 	*/
 
 	// do the python learning
-	if err = learnFromData(family, datasLearn); err != nil {
+	if err = learnFromData(ctx, family, datasLearn, offsets, job); err != nil {
+		return
+	}
+	if err = learnHierarchy(ctx, family, datasLearn, offsets, db, job); err != nil {
+		return
+	}
+	// classify with the models just learned, with the corrections and
+	// sensors they were learned with
+	keyValues := map[string]interface{}{
+		"DeviceOffsets": offsets,
+		"DriftBaseline": baseline,
+		"FeatureSet":    features,
+		"ModelFamily":   family,
+	}
+	if errNB == nil {
+		keyValues["NB1"] = nb.Data
+		keyValues["NB1Counts"] = nb.Counts
+	}
+	if err = db.SetMany(keyValues); err != nil {
 		return
 	}
+	useDriftBaseline(family, baseline)
+	classifications.invalidate(family)
 
-	if len(crossValidation) > 0 && crossValidation[0] {
-		go findBestAlgorithm(datasTest, db)
+	// learn the location transitions for smoothing
+	if errFit := FitSmoothing(family, db); errFit != nil {
+		logger.Log.Warnf("[%s] problem fitting smoothing: %s", family, errFit.Error())
 	}
 	return
 }
@@ -118,60 +173,32 @@ func splitDataForLearning(datas []models.SensorData, crossValidation ...bool) (d
 	return
 }
 
-func learnFromData(family string, datas []models.SensorData) (err error) {
+func learnFromData(ctx context.Context, family string, datas []models.SensorData, offsets map[string]models.DeviceOffset, job *calibrationJob) (err error) {
 	// inquire the AI
-	type Payload struct {
-		Family     string `json:"family"`
-		CSVFile    string `json:"csv_file"`
-		DataFolder string `json:"data_folder"`
-	}
-	var p Payload
-	p.CSVFile = utils.RandomString(8) + ".csv"
-	p.Family = family
-	p.DataFolder = DataFolder
-
-	logger.Log.Debugf("[%s] writing data to %s", family, path.Join(p.DataFolder, p.CSVFile))
-	err = dumpSensorsToCSV(datas, path.Join(p.DataFolder, p.CSVFile))
-	if err != nil {
-		return
-	}
-	defer os.Remove(path.Join(p.DataFolder, p.CSVFile))
-
-	url := "http://127.0.0.1:" + AIPort + "/learn"
-	bPayload, err := json.Marshal(p)
-	if err != nil {
-		return
-	}
-	logger.Log.Debugf("sending payload: %s", bPayload)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(bPayload))
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := httpClient.Do(req)
+	csvFile := utils.RandomString(8) + ".csv"
+	logger.Log.Debugf("[%s] writing data to %s", family, path.Join(DataFolder, csvFile))
+	err = dumpSensorsToCSV(datas, path.Join(DataFolder, csvFile), offsets)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
+	defer os.Remove(path.Join(DataFolder, csvFile))
+	job.setPhase("fit")
 
-	var target AnalysisResponse
-	err = json.NewDecoder(resp.Body).Decode(&target)
+	err = getAIClient().Learn(ctx, family, csvFile, DataFolder)
 	if err != nil {
+		logger.Log.Debugf("failure: %s", err.Error())
 		return
 	}
-
-	if target.Success {
-		logger.Log.Debugf("success: %s", target.Message)
-	} else {
-		logger.Log.Debugf("failure: %s", target.Message)
-		err = errors.New("failed in AI server: " + target.Message)
-	}
+	classifications.invalidate(family)
 	return
 }
 
-func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algorithmEfficacy map[string]map[string]models.BinaryStats, err error) {
+func findBestAlgorithm(ctx context.Context, datas []models.SensorData, db *database.Database, job *calibrationJob) (algorithmEfficacy map[string]map[string]models.BinaryStats, err error) {
 	if len(datas) == 0 {
 		err = errors.New("no data specified")
 		return
 	}
-	predictionAnalysis := make(map[string]map[string]map[string]int)
+	var predictionAnalysis map[string]map[string]map[string]int
 	logger.Log.Debugf("[%s] finding best algorithm for %d data", datas[0].Family, len(datas))
 
 	t := time.Now()
@@ -186,14 +213,21 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	jobs := make(chan Job, len(datas))
 	results := make(chan Result, len(datas))
 	workers := 9
+	job.setPhase("cross-validate")
+	job.setWorkers(workers, len(datas))
 	for w := 0; w < workers; w++ {
 		go func(id int, jobs <-chan Job, results chan<- Result) {
-			for job := range jobs {
-				aidata, err := AnalyzeSensorData(job.data, db)
+			for j := range jobs {
+				if ctx.Err() != nil {
+					results <- Result{i: j.i}
+					continue
+				}
+				aidata, err := analyzeSensorData(ctx, j.data, db)
 				if err != nil {
-					logger.Log.Warnf("%s: %+v", err.Error(), job.data)
+					logger.Log.Warnf("%s: %+v", err.Error(), j.data)
 				}
-				results <- Result{data: aidata, i: job.i}
+				job.workerDone(id)
+				results <- Result{data: aidata, i: j.i}
 			}
 		}(w, jobs, results)
 	}
@@ -206,94 +240,60 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 		result := <-results
 		aidatas[result.i] = result.data
 	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
 	logger.Log.Infof("[%s] analyzed %d data in %s", datas[0].Family, len(datas), time.Since(t))
+	job.setPhase("score")
 
-	for i, aidata := range aidatas {
-		for _, prediction := range aidata.Predictions {
-			if _, ok := predictionAnalysis[prediction.Name]; !ok {
-				predictionAnalysis[prediction.Name] = make(map[string]map[string]int)
-				for trueLoc := range aidata.LocationNames {
-					predictionAnalysis[prediction.Name][aidata.LocationNames[trueLoc]] = make(map[string]int)
-					for guessLoc := range aidata.LocationNames {
-						predictionAnalysis[prediction.Name][aidata.LocationNames[trueLoc]][aidata.LocationNames[guessLoc]] = 0
-					}
-				}
-			}
-			correctLocation := datas[i].Location
-			if len(prediction.Locations) == 0 {
-				logger.Log.Warn("prediction.Locations is empty!")
-				continue
-			}
-			if len(aidata.LocationNames) == 0 {
-				err = errors.New("no location names")
-				logger.Log.Error(err)
-				return
-			}
-			guessedLocation := aidata.LocationNames[prediction.Locations[0]]
-			predictionAnalysis[prediction.Name][correctLocation][guessedLocation]++
-		}
+	if predictionAnalysis, err = analyzePredictions(aidatas, datas); err != nil {
+		return
 	}
+	algorithmEfficacy = efficacyOfPredictions(predictionAnalysis)
 
-	// normalize prediction analysis
-	// initialize location totals
-	locationTotals := make(map[string]int)
-	for _, data := range datas {
-		if _, ok := locationTotals[data.Location]; !ok {
-			locationTotals[data.Location] = 0
-		}
-		locationTotals[data.Location]++
+	// fit the ensembles, and compare them side by side on the folds they were not fit on
+	params := fitEnsembles(aidatas, datas, algorithmEfficacy, predictionAnalysis)
+	crossGuesses := crossValidateEnsembles(aidatas, datas)
+	ensembleAccuracy := evaluateEnsembles(crossGuesses, datas)
+	for name, accuracy := range ensembleAccuracy {
+		logger.Log.Infof("[%s] %s ensemble accuracy: %2.0f%%", datas[0].Family, name, accuracy*100)
 	}
-	logger.Log.Debugf("locationTotals: %+v", locationTotals)
-	algorithmEfficacy = make(map[string]map[string]models.BinaryStats)
-	for alg := range predictionAnalysis {
-		if _, ok := algorithmEfficacy[alg]; !ok {
-			algorithmEfficacy[alg] = make(map[string]models.BinaryStats)
-		}
-		for correctLocation := range predictionAnalysis[alg] {
-			// calculate true/false positives/negatives
-			tp := 0
-			fp := 0
-			tn := 0
-			fn := 0
-			for guessedLocation := range predictionAnalysis[alg][correctLocation] {
-				count := predictionAnalysis[alg][correctLocation][guessedLocation]
-				if guessedLocation == correctLocation {
-					tp += count
-				} else if guessedLocation != correctLocation {
-					fn += count
-				}
-			}
-			for otherCorrectLocation := range predictionAnalysis[alg] {
-				if otherCorrectLocation == correctLocation {
-					continue
-				}
-				for guessedLocation := range predictionAnalysis[alg] {
-					count := predictionAnalysis[alg][otherCorrectLocation][guessedLocation]
-					if guessedLocation == correctLocation {
-						fp += count
-					} else if guessedLocation != correctLocation {
-						tn += count
-					}
-				}
-			}
-			algorithmEfficacy[alg][correctLocation] = models.NewBinaryStats(tp, fp, tn, fn)
-		}
+	strategyName := GetEnsembleStrategy(db)
+	strategy, err := NewEnsembleStrategy(strategyName, params)
+	if err != nil {
+		logger.Log.Warnf("[%s] using %s ensemble: %s", datas[0].Family, DefaultEnsembleStrategy, err.Error())
+		strategyName = DefaultEnsembleStrategy
+		strategy, _ = NewEnsembleStrategy(DefaultEnsembleStrategy, params)
+		err = nil
 	}
 
 	correct := 0
 	ProbabilitiesOfBestGuess := make([]float64, len(aidatas))
 	accuracyBreakdown := make(map[string]float64)
 	accuracyBreakdownTotal := make(map[string]float64)
+	ensembleConfusion := make(models.ConfusionMatrix)
+	bestGuessProbabilities := make(map[string][]float64)
+	coordinates := GetLocationCoordinates(datas[0].Family, db)
+	positionErrors := []float64{}
+	positionFloors := 0
 	for i := range aidatas {
 		if _, ok := accuracyBreakdownTotal[datas[i].Location]; !ok {
 			accuracyBreakdownTotal[datas[i].Location] = 0
 			accuracyBreakdown[datas[i].Location] = 0
+			ensembleConfusion[datas[i].Location] = make(map[string]int)
 		}
 		accuracyBreakdownTotal[datas[i].Location]++
-		bestGuess := determineBestGuess(aidatas[i], algorithmEfficacy)
+		// the guesses of the fold the ensemble was not fit on, if there are folds
+		var bestGuess []models.LocationPrediction
+		if guesses, ok := crossGuesses[strategyName]; ok {
+			bestGuess = guesses[i]
+		} else {
+			bestGuess = strategy.Combine(aidatas[i])
+		}
 		if len(bestGuess) == 0 {
 			continue
 		}
+		ensembleConfusion[datas[i].Location][bestGuess[0].Location]++
 		if bestGuess[0].Location == datas[i].Location {
 			accuracyBreakdown[datas[i].Location]++
 			correct++
@@ -301,6 +301,15 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 		} else {
 			ProbabilitiesOfBestGuess[i] = -1 * bestGuess[0].Probability
 		}
+		bestGuessProbabilities[datas[i].Location] = append(bestGuessProbabilities[datas[i].Location], ProbabilitiesOfBestGuess[i])
+		if c, ok := coordinates[datas[i].Location]; ok {
+			if p := models.EstimatePosition(bestGuess, coordinates, PositionGuesses); p != nil {
+				positionFloors++
+				if p.Floor == c.Floor {
+					positionErrors = append(positionErrors, models.Distance(p.X, p.Y, c.X, c.Y))
+				}
+			}
+		}
 	}
 	logger.Log.Infof("[%s] total correct: %d/%d", datas[0].Family, correct, len(aidatas))
 
@@ -328,6 +337,17 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	badMean := average(badProbs)
 	badSD := stdDev(badProbs, badMean)
 
+	minimumProbability := determineMinimumProbability(goodProbs, goodMean, goodSD)
+	logger.Log.Infof("[%s] minimum probability: %2.3f", datas[0].Family, minimumProbability)
+
+	// positioning errors are only measured on the correct floor
+	var meanPositionError, floorAccuracy float64
+	if len(positionErrors) > 0 {
+		meanPositionError = average(positionErrors)
+		floorAccuracy = float64(len(positionErrors)) / float64(positionFloors)
+		logger.Log.Infof("[%s] mean position error: %2.1f m, floor accuracy: %2.0f%%", datas[0].Family, meanPositionError, floorAccuracy*100)
+	}
+
 	for loc := range accuracyBreakdown {
 		accuracyBreakdown[loc] = accuracyBreakdown[loc] / accuracyBreakdownTotal[loc]
 		logger.Log.Infof("[%s] %s accuracy: %2.0f%%", datas[0].Family, loc, accuracyBreakdown[loc]*100)
@@ -341,6 +361,10 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	err = db.Set("MinimumProbability", minimumProbability)
+	if err != nil {
+		logger.Log.Error(err)
+	}
 	err = db.Set("PercentCorrect", float64(correct)/float64(len(datas)))
 	if err != nil {
 		logger.Log.Error(err)
@@ -349,6 +373,22 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	err = db.Set("MeanPositionError", meanPositionError)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = db.Set("FloorAccuracy", floorAccuracy)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = db.Set("EnsembleConfusion", ensembleConfusion)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = db.Set("BestGuessProbabilities", bestGuessProbabilities)
+	if err != nil {
+		logger.Log.Error(err)
+	}
 	err = db.Set("PredictionAnalysis", predictionAnalysis)
 	if err != nil {
 		logger.Log.Error(err)
@@ -357,10 +397,24 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	err = db.Set("BestAlgorithm", params.BestAlgorithm)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = db.Set("StackedModel", params.Stacked)
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	err = db.Set("EnsembleAccuracy", ensembleAccuracy)
+	if err != nil {
+		logger.Log.Error(err)
+	}
 	err = db.Set("LastCalibrationTime", time.Now().UTC())
 	if err != nil {
 		logger.Log.Error(err)
 	}
+	job.setMetrics(float64(correct)/float64(len(datas)), accuracyBreakdown, minimumProbability, ensembleAccuracy)
+	job.setPositionError(meanPositionError, floorAccuracy)
 
 	// generate location analysis images
 	//go GenerateImages(datas[0].Family)
@@ -368,6 +422,103 @@ func findBestAlgorithm(datas []models.SensorData, db *database.Database) (algori
 	return
 }
 
+// determineMinimumProbability finds the lowest best-guess probability that is
+// still credible, using the probabilities of the correct guesses made during
+// cross validation (mean - 2*std). A zero value disables unknown detection.
+func determineMinimumProbability(goodProbs []float64, goodMean, goodSD float64) (minimumProbability float64) {
+	if len(goodProbs) < 2 || math.IsNaN(goodMean) || math.IsNaN(goodSD) || math.IsInf(goodSD, 0) {
+		return
+	}
+	minimumProbability = goodMean - 2*goodSD
+	if minimumProbability < 0 {
+		minimumProbability = 0
+	}
+	return
+}
+
+// analyzePredictions counts, for each algorithm, how often each location
+// was guessed for the data of each true location
+func analyzePredictions(aidatas []models.LocationAnalysis, datas []models.SensorData) (predictionAnalysis map[string]map[string]map[string]int, err error) {
+	predictionAnalysis = make(map[string]map[string]map[string]int)
+	for i, aidata := range aidatas {
+		for _, prediction := range aidata.Predictions {
+			if _, ok := predictionAnalysis[prediction.Name]; !ok {
+				predictionAnalysis[prediction.Name] = make(map[string]map[string]int)
+				for trueLoc := range aidata.LocationNames {
+					predictionAnalysis[prediction.Name][aidata.LocationNames[trueLoc]] = make(map[string]int)
+					for guessLoc := range aidata.LocationNames {
+						predictionAnalysis[prediction.Name][aidata.LocationNames[trueLoc]][aidata.LocationNames[guessLoc]] = 0
+					}
+				}
+			}
+			correctLocation := datas[i].Location
+			if len(prediction.Locations) == 0 {
+				logger.Log.Warn("prediction.Locations is empty!")
+				continue
+			}
+			if len(aidata.LocationNames) == 0 {
+				err = errors.New("no location names")
+				logger.Log.Error(err)
+				return
+			}
+			guessedLocation := aidata.LocationNames[prediction.Locations[0]]
+			predictionAnalysis[prediction.Name][correctLocation][guessedLocation]++
+		}
+	}
+
+	// initialize location totals
+	locationTotals := make(map[string]int)
+	for _, data := range datas {
+		if _, ok := locationTotals[data.Location]; !ok {
+			locationTotals[data.Location] = 0
+		}
+		locationTotals[data.Location]++
+	}
+	logger.Log.Debugf("locationTotals: %+v", locationTotals)
+	return
+}
+
+// efficacyOfPredictions returns the true and false positives and negatives
+// of each algorithm for each location
+func efficacyOfPredictions(predictionAnalysis map[string]map[string]map[string]int) (algorithmEfficacy map[string]map[string]models.BinaryStats) {
+	algorithmEfficacy = make(map[string]map[string]models.BinaryStats)
+	for alg := range predictionAnalysis {
+		if _, ok := algorithmEfficacy[alg]; !ok {
+			algorithmEfficacy[alg] = make(map[string]models.BinaryStats)
+		}
+		for correctLocation := range predictionAnalysis[alg] {
+			// calculate true/false positives/negatives
+			tp := 0
+			fp := 0
+			tn := 0
+			fn := 0
+			for guessedLocation := range predictionAnalysis[alg][correctLocation] {
+				count := predictionAnalysis[alg][correctLocation][guessedLocation]
+				if guessedLocation == correctLocation {
+					tp += count
+				} else if guessedLocation != correctLocation {
+					fn += count
+				}
+			}
+			for otherCorrectLocation := range predictionAnalysis[alg] {
+				if otherCorrectLocation == correctLocation {
+					continue
+				}
+				for guessedLocation := range predictionAnalysis[alg] {
+					count := predictionAnalysis[alg][otherCorrectLocation][guessedLocation]
+					if guessedLocation == correctLocation {
+						fp += count
+					} else if guessedLocation != correctLocation {
+						tn += count
+					}
+				}
+			}
+			algorithmEfficacy[alg][correctLocation] = models.NewBinaryStats(tp, fp, tn, fn)
+		}
+	}
+	return
+}
+
 func average(xs []float64) float64 {
 	total := 0.0
 	for _, v := range xs {
@@ -385,7 +536,7 @@ func stdDev(numbers []float64, mean float64) float64 {
 	return math.Sqrt(variance)
 }
 
-func dumpSensorsToCSV(datas []models.SensorData, csvFile string) (err error) {
+func dumpSensorsToCSV(datas []models.SensorData, csvFile string, offsets map[string]models.DeviceOffset) (err error) {
 	if len(datas) == 0 {
 		err = errors.New("data is empty")
 		return
@@ -398,6 +549,16 @@ func dumpSensorsToCSV(datas []models.SensorData, csvFile string) (err error) {
 	}
 	defer f.Close()
 
+	// every value is a number for the machine learning, categories have a column each
+	encoded := make([]models.SensorData, len(datas))
+	for i, data := range datas {
+		if offset, ok := offsets[data.Device]; ok {
+			data = offset.Apply(data)
+		}
+		encoded[i] = data.Encoded()
+	}
+	datas = encoded
+
 	// determine all possible columns
 	sensorColumns := make(map[string]int)
 	columnCount := 1
@@ -3,6 +3,7 @@ package api
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -76,15 +77,15 @@ func BenchmarkDumpToCSV(b *testing.B) {
 	db, _ := database.Open("testing")
 	defer db.Close()
 	json.Unmarshal([]byte(j), &s)
-	db.AddSensor(s)
+	db.StoreSensorData(s)
 	json.Unmarshal([]byte(j2), &s)
-	db.AddSensor(s)
+	db.StoreSensorData(s)
 	ss, _ := db.GetAllForClassification()
 
 	db.Debug(false)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		dumpSensorsToCSV(ss, "test.csv")
+		dumpSensorsToCSV(ss, "test.csv", nil)
 	}
 
 }
@@ -94,13 +95,13 @@ func TestDumpSensorsToCSV(t *testing.T) {
 	db, _ := database.Open("testing")
 	defer db.Close()
 	json.Unmarshal([]byte(j), &s)
-	db.AddSensor(s)
+	db.StoreSensorData(s)
 	json.Unmarshal([]byte(j2), &s)
-	db.AddSensor(s)
+	db.StoreSensorData(s)
 	ss, _ := db.GetAllForClassification()
 
 	db.Debug(false)
-	err := dumpSensorsToCSV(ss, "test.csv")
+	err := dumpSensorsToCSV(ss, "test.csv", nil)
 	assert.Nil(t, err)
 }
 
@@ -110,9 +111,9 @@ func TestRisingEfficacy(t *testing.T) {
 
 	db, err := database.Open("pike5")
 	assert.Nil(t, err)
+	defer db.Close()
 	datas, err := db.GetAllForClassification()
 	assert.Nil(t, err)
-	db.Close()
 	datas = datas[:2000]
 	fmt.Println(len(datas))
 
@@ -121,10 +122,10 @@ func TestRisingEfficacy(t *testing.T) {
 	fmt.Println(len(datasLearn))
 	fmt.Println(len(datasTest))
 
-	err = learnFromData("pike5", datasLearn)
+	err = learnFromData(context.Background(), "pike5", datasLearn, nil, nil)
 	assert.Nil(t, err)
 
-	algorithmEfficacy, err := findBestAlgorithm(datasTest)
+	algorithmEfficacy, err := findBestAlgorithm(context.Background(), datasTest, db, nil)
 	assert.Nil(t, err)
 	// bA, _ := json.MarshalIndent(algorithmEfficacy, "", " ")
 	// fmt.Println(string(bA))
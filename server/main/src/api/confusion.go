@@ -0,0 +1,31 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// confusedPairs is the number of most confused location pairs that are reported
+const confusedPairs = 10
+
+// GetConfusionReport returns the confusion matrices and the diagnostics of the last calibration
+func GetConfusionReport(db *database.Database) (report models.ConfusionReport, err error) {
+	var algorithms map[string]models.ConfusionMatrix
+	var ensemble models.ConfusionMatrix
+	var probabilities map[string][]float64
+	keyValues := make(map[string]interface{})
+	keyValues["PredictionAnalysis"] = &algorithms
+	keyValues["EnsembleConfusion"] = &ensemble
+	keyValues["BestGuessProbabilities"] = &probabilities
+	if err = db.GetMany(keyValues); err != nil {
+		err = errors.Wrap(err, "could not get confusion")
+		return
+	}
+	if algorithms == nil || ensemble == nil {
+		err = errors.New("no confusion matrix, need to calibrate")
+		return
+	}
+	report = models.NewConfusionReport(algorithms, ensemble, probabilities, confusedPairs)
+	return
+}
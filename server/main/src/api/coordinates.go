@@ -0,0 +1,105 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// PositionGuesses is the number of best guesses averaged into a position
+const PositionGuesses = 3
+
+// CoordinatesTTL is how long the coordinates of a family are cached. They
+// are dropped at once when they are set, and the gps table is read again
+// after this long.
+var CoordinatesTTL = 1 * time.Minute
+
+type cachedCoordinates struct {
+	coordinates map[string]models.Coordinates
+	expires     time.Time
+}
+
+type coordinatesCache struct {
+	// Families maps family -> its coordinates
+	Families map[string]cachedCoordinates
+	// Versions maps family -> version, which changes when its coordinates are set
+	Versions map[string]int
+	sync.Mutex
+}
+
+var globalCoordinates coordinatesCache
+
+func init() {
+	globalCoordinates.Lock()
+	defer globalCoordinates.Unlock()
+	globalCoordinates.Families = make(map[string]cachedCoordinates)
+	globalCoordinates.Versions = make(map[string]int)
+}
+
+// GetLocationCoordinates returns the coordinates of the locations of the family.
+// Locations without coordinates use their position in the gps table, if any.
+func GetLocationCoordinates(family string, db *database.Database) (coordinates map[string]models.Coordinates) {
+	globalCoordinates.Lock()
+	cached, ok := globalCoordinates.Families[family]
+	version := globalCoordinates.Versions[family]
+	globalCoordinates.Unlock()
+	if !ok || time.Now().After(cached.expires) {
+		cached = cachedCoordinates{
+			coordinates: loadLocationCoordinates(db),
+			expires:     time.Now().Add(CoordinatesTTL),
+		}
+		globalCoordinates.Lock()
+		// coordinates that were set while loading are newer
+		if globalCoordinates.Versions[family] == version {
+			globalCoordinates.Families[family] = cached
+		}
+		globalCoordinates.Unlock()
+	}
+	// copy, so that the cached coordinates are not changed
+	coordinates = make(map[string]models.Coordinates, len(cached.coordinates))
+	for location, c := range cached.coordinates {
+		coordinates[location] = c
+	}
+	return
+}
+
+// loadLocationCoordinates reads the coordinates of the family from the database
+func loadLocationCoordinates(db *database.Database) (coordinates map[string]models.Coordinates) {
+	coordinates = make(map[string]models.Coordinates)
+	if gps, err := db.GetLocationGPS(); err == nil {
+		coordinates = models.ProjectGPS(gps)
+	}
+	var set map[string]models.Coordinates
+	if err := db.Get("LocationCoordinates", &set); err == nil {
+		for location, c := range set {
+			coordinates[location] = c
+		}
+	}
+	return
+}
+
+// invalidateCoordinates drops the cached coordinates of the family
+func invalidateCoordinates(family string) {
+	globalCoordinates.Lock()
+	defer globalCoordinates.Unlock()
+	globalCoordinates.Versions[family]++
+	delete(globalCoordinates.Families, family)
+}
+
+// SetLocationCoordinates saves the coordinates of the locations of the family
+func SetLocationCoordinates(family string, coordinates map[string]models.Coordinates, db *database.Database) (err error) {
+	for location := range coordinates {
+		if location == "" {
+			err = errors.New("location cannot be empty")
+			return
+		}
+	}
+	if err = db.Set("LocationCoordinates", coordinates); err != nil {
+		return
+	}
+	invalidateCoordinates(family)
+	return
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DriftCheckEvery is the number of tracking fingerprints of a family between
+// two checks of its drift against the calibration policy
+const DriftCheckEvery = 50
+
+// OnDriftAlert is called when the drift of a family exceeds the alert
+// threshold of its calibration policy, at most once per debounce
+var OnDriftAlert func(family string, report models.DriftReport)
+
+type driftMonitors struct {
+	Monitors  map[string]*models.DriftMonitor
+	Baselines map[string]*models.DriftBaseline
+	// LastAlert maps family -> time of the last alert
+	LastAlert map[string]time.Time
+	sync.Mutex
+}
+
+var globalDrift driftMonitors
+
+func init() {
+	globalDrift.Lock()
+	defer globalDrift.Unlock()
+	globalDrift.Monitors = make(map[string]*models.DriftMonitor)
+	globalDrift.Baselines = make(map[string]*models.DriftBaseline)
+	globalDrift.LastAlert = make(map[string]time.Time)
+}
+
+// useDriftBaseline starts comparing the tracking data of the family against
+// the environment of the learning data of a calibration, once it is saved
+func useDriftBaseline(family string, baseline models.DriftBaseline) {
+	globalDrift.Lock()
+	globalDrift.Baselines[family] = &baseline
+	globalDrift.Monitors[family] = models.NewDriftMonitor()
+	globalDrift.Unlock()
+}
+
+// getDriftBaseline returns the baseline of the family, loading it if needed.
+// Call it with globalDrift locked.
+func getDriftBaseline(family string, db *database.Database) (baseline *models.DriftBaseline, err error) {
+	baseline, ok := globalDrift.Baselines[family]
+	if ok {
+		return
+	}
+	baseline = new(models.DriftBaseline)
+	if err = db.Get("DriftBaseline", baseline); err != nil {
+		err = errors.Wrap(err, "no drift baseline, need to calibrate")
+		return
+	}
+	globalDrift.Baselines[family] = baseline
+	return
+}
+
+// ObserveDrift adds a classified tracking fingerprint to the drift of its
+// family, and checks the calibration policy every DriftCheckEvery fingerprints
+func ObserveDrift(s models.SensorData, aidata models.LocationAnalysis, db *database.Database) (err error) {
+	if aidata.IsUnknown || len(aidata.Guesses) == 0 || s.Location != "" {
+		return
+	}
+	if offset, ok := GetDeviceOffsets(db)[s.Device]; ok {
+		s = offset.Apply(s)
+	}
+
+	globalDrift.Lock()
+	baseline, err := getDriftBaseline(s.Family, db)
+	if err != nil {
+		globalDrift.Unlock()
+		return
+	}
+	m, ok := globalDrift.Monitors[s.Family]
+	if !ok {
+		m = models.NewDriftMonitor()
+		globalDrift.Monitors[s.Family] = m
+	}
+	m.Observe(aidata.Guesses[0].Location, s)
+	if m.Observed%DriftCheckEvery != 0 {
+		globalDrift.Unlock()
+		return
+	}
+	report := m.Report(*baseline)
+	globalDrift.Unlock()
+
+	logger.Log.Debugf("[%s] drift score %2.3f", s.Family, report.Score)
+	policy := GetCalibrationPolicy(db)
+	if policy.DriftAlert > 0 && report.Score >= policy.DriftAlert {
+		alertDrift(s.Family, report, policy)
+	}
+	go NotifyDrift(s.Family, report.Score)
+	return
+}
+
+// alertDrift logs the drift and calls OnDriftAlert, unless it did recently
+func alertDrift(family string, report models.DriftReport, policy models.CalibrationPolicy) {
+	debounce := time.Duration(policy.DebounceMinutes) * time.Minute
+	if debounce <= 0 {
+		debounce = time.Hour
+	}
+	globalDrift.Lock()
+	if time.Since(globalDrift.LastAlert[family]) < debounce {
+		globalDrift.Unlock()
+		return
+	}
+	globalDrift.LastAlert[family] = time.Now()
+	globalDrift.Unlock()
+
+	logger.Log.Warnf("[%s] drift of %2.2f exceeds the alert threshold %2.2f", family, report.Score, policy.DriftAlert)
+	if OnDriftAlert != nil {
+		OnDriftAlert(family, report)
+	}
+}
+
+// GetDriftReport compares the tracking data since the last calibration with its learning data
+func GetDriftReport(family string, db *database.Database) (report models.DriftReport, err error) {
+	globalDrift.Lock()
+	defer globalDrift.Unlock()
+	baseline, err := getDriftBaseline(family, db)
+	if err != nil {
+		return
+	}
+	m, ok := globalDrift.Monitors[family]
+	if !ok {
+		m = models.NewDriftMonitor()
+	}
+	report = m.Report(*baseline)
+	return
+}
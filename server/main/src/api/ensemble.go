@@ -0,0 +1,225 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/learning/stacked"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultEnsembleStrategy weights the probability of each algorithm by its informedness
+const DefaultEnsembleStrategy = "informedness"
+
+// EnsembleStrategies are the names of the available ensemble strategies
+var EnsembleStrategies = []string{"informedness", "mcc", "average", "best", "stacked"}
+
+// EnsembleStrategy combines the predictions of the algorithms into location guesses
+type EnsembleStrategy interface {
+	Combine(aidata models.LocationAnalysis) []models.LocationPrediction
+}
+
+// weighter is an ensemble strategy that weights the probability of each
+// algorithm for each location
+type weighter interface {
+	Weight(algorithm, location string) float64
+}
+
+// EnsembleParameters are learned during calibration and used by the ensemble strategies
+type EnsembleParameters struct {
+	AlgorithmEfficacy map[string]map[string]models.BinaryStats
+	// BestAlgorithm is the algorithm with the most correct guesses
+	BestAlgorithm string
+	// Stacked is the logistic model stacked on the algorithms
+	Stacked *stacked.Model
+}
+
+// NewEnsembleStrategy returns the named ensemble strategy
+func NewEnsembleStrategy(name string, params EnsembleParameters) (strategy EnsembleStrategy, err error) {
+	switch name {
+	case "informedness":
+		strategy = informednessStrategy{params.AlgorithmEfficacy}
+	case "mcc":
+		strategy = mccStrategy{params.AlgorithmEfficacy}
+	case "average":
+		strategy = averageStrategy{}
+	case "best":
+		if params.BestAlgorithm == "" {
+			err = errors.New("no best algorithm, need to calibrate")
+			return
+		}
+		strategy = bestAlgorithmStrategy{params.BestAlgorithm}
+	case "stacked":
+		if params.Stacked == nil {
+			err = errors.New("no stacked model, need to calibrate")
+			return
+		}
+		strategy = stackedStrategy{params.Stacked}
+	default:
+		err = errors.Errorf("unknown ensemble strategy '%s'", name)
+	}
+	return
+}
+
+// GetEnsembleStrategy returns the name of the ensemble strategy of the family
+func GetEnsembleStrategy(db *database.Database) (name string) {
+	if err := db.Get("EnsembleStrategy", &name); err != nil || name == "" {
+		name = DefaultEnsembleStrategy
+	}
+	return
+}
+
+// SetEnsembleStrategy sets the ensemble strategy of the family
+func SetEnsembleStrategy(name string, db *database.Database) (err error) {
+	if _, err = NewEnsembleStrategy(name, EnsembleParameters{BestAlgorithm: "-", Stacked: stacked.New()}); err != nil {
+		return
+	}
+	err = db.Set("EnsembleStrategy", name)
+	return
+}
+
+// fitEnsembles learns the ensemble parameters from the cross validation analyses
+func fitEnsembles(aidatas []models.LocationAnalysis, datas []models.SensorData, algorithmEfficacy map[string]map[string]models.BinaryStats, predictionAnalysis map[string]map[string]map[string]int) (params EnsembleParameters) {
+	params.AlgorithmEfficacy = algorithmEfficacy
+
+	bestCorrect := -1
+	for alg := range predictionAnalysis {
+		correct := 0
+		for loc := range predictionAnalysis[alg] {
+			correct += predictionAnalysis[alg][loc][loc]
+		}
+		if correct > bestCorrect || (correct == bestCorrect && alg < params.BestAlgorithm) {
+			bestCorrect = correct
+			params.BestAlgorithm = alg
+		}
+	}
+
+	trueLocations := make([]string, len(datas))
+	for i := range datas {
+		trueLocations[i] = datas[i].Location
+	}
+	params.Stacked = stacked.New()
+	if err := params.Stacked.Fit(aidatas, trueLocations); err != nil {
+		logger.Log.Warnf("problem fitting stacked model: %s", err.Error())
+		params.Stacked = nil
+	}
+	return
+}
+
+// EnsembleFolds is the number of folds of the analyses on which the ensembles
+// are fit and scored, each fold being scored by the ensembles fit on the others
+const EnsembleFolds = 5
+
+// crossValidateEnsembles returns the guesses of each ensemble strategy for
+// each analysis, from the ensembles fit on the other folds of the analyses,
+// so that no strategy is scored on data it was fit on. Strategies that could
+// not be fit on some fold have no guesses for its analyses.
+func crossValidateEnsembles(aidatas []models.LocationAnalysis, datas []models.SensorData) (guesses map[string][][]models.LocationPrediction) {
+	guesses = make(map[string][][]models.LocationPrediction)
+	folds := EnsembleFolds
+	if folds > len(aidatas) {
+		folds = len(aidatas)
+	}
+	if folds < 2 {
+		return
+	}
+	for fold := 0; fold < folds; fold++ {
+		var fitAidatas []models.LocationAnalysis
+		var fitDatas []models.SensorData
+		for i := range aidatas {
+			if i%folds != fold {
+				fitAidatas = append(fitAidatas, aidatas[i])
+				fitDatas = append(fitDatas, datas[i])
+			}
+		}
+		predictionAnalysis, err := analyzePredictions(fitAidatas, fitDatas)
+		if err != nil {
+			continue
+		}
+		params := fitEnsembles(fitAidatas, fitDatas, efficacyOfPredictions(predictionAnalysis), predictionAnalysis)
+		for _, name := range EnsembleStrategies {
+			strategy, err := NewEnsembleStrategy(name, params)
+			if err != nil {
+				continue
+			}
+			if _, ok := guesses[name]; !ok {
+				guesses[name] = make([][]models.LocationPrediction, len(aidatas))
+			}
+			for i := fold; i < len(aidatas); i += folds {
+				guesses[name][i] = strategy.Combine(aidatas[i])
+			}
+		}
+	}
+	return
+}
+
+// evaluateEnsembles returns the accuracy of the guesses of each ensemble strategy
+func evaluateEnsembles(guesses map[string][][]models.LocationPrediction, datas []models.SensorData) (accuracy map[string]float64) {
+	accuracy = make(map[string]float64)
+	for name := range guesses {
+		correct := 0
+		for i := range guesses[name] {
+			if len(guesses[name][i]) > 0 && guesses[name][i][0].Location == datas[i].Location {
+				correct++
+			}
+		}
+		accuracy[name] = float64(correct) / float64(len(datas))
+	}
+	return
+}
+
+type informednessStrategy struct {
+	algorithmEfficacy map[string]map[string]models.BinaryStats
+}
+
+func (s informednessStrategy) Combine(aidata models.LocationAnalysis) []models.LocationPrediction {
+	return determineBestGuess(aidata, s.algorithmEfficacy)
+}
+
+func (s informednessStrategy) Weight(algorithm, location string) float64 {
+	return s.algorithmEfficacy[algorithm][location].Informedness
+}
+
+type mccStrategy struct {
+	algorithmEfficacy map[string]map[string]models.BinaryStats
+}
+
+func (s mccStrategy) Combine(aidata models.LocationAnalysis) []models.LocationPrediction {
+	return weightedGuesses(aidata, s.Weight)
+}
+
+func (s mccStrategy) Weight(algorithm, location string) float64 {
+	return s.algorithmEfficacy[algorithm][location].MCC
+}
+
+type averageStrategy struct{}
+
+func (s averageStrategy) Combine(aidata models.LocationAnalysis) []models.LocationPrediction {
+	return weightedGuesses(aidata, s.Weight)
+}
+
+func (s averageStrategy) Weight(algorithm, location string) float64 {
+	return 1
+}
+
+type bestAlgorithmStrategy struct {
+	algorithm string
+}
+
+func (s bestAlgorithmStrategy) Combine(aidata models.LocationAnalysis) []models.LocationPrediction {
+	return weightedGuesses(aidata, s.Weight)
+}
+
+func (s bestAlgorithmStrategy) Weight(algorithm, location string) float64 {
+	if algorithm == s.algorithm {
+		return 1
+	}
+	return 0
+}
+
+type stackedStrategy struct {
+	model *stacked.Model
+}
+
+func (s stackedStrategy) Combine(aidata models.LocationAnalysis) []models.LocationPrediction {
+	return guessesFromScores(s.model.Score(aidata))
+}
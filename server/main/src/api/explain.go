@@ -0,0 +1,58 @@
+package api
+
+import (
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/learning/nb1"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// ExplainGuesses is the number of best guesses that are explained
+const ExplainGuesses = 3
+
+// ExplainAnalysis adds to the analysis what each sensor of the sensor data
+// added to the best guesses, according to the Go naive bayes classifier,
+// and the weight the ensemble gave to each algorithm
+func ExplainAnalysis(s models.SensorData, aidata *models.LocationAnalysis, db *database.Database) (err error) {
+	explanation := &models.Explanation{Locations: []models.LocationExplanation{}}
+	// explain the sensor data the way it was classified
+	s = prepareSensorData(s, db)
+	nb := nb1.New()
+	for i, guess := range aidata.Guesses {
+		if i == ExplainGuesses {
+			break
+		}
+		if guess.Location == "?" {
+			continue
+		}
+		locationExplanation, errExplain := nb.Explain(s, guess.Location)
+		if errExplain != nil {
+			logger.Log.Debugf("[%s] could not explain %s: %s", s.Family, guess.Location, errExplain.Error())
+			err = errExplain
+			continue
+		}
+		explanation.Locations = append(explanation.Locations, locationExplanation)
+	}
+
+	strategy, _ := getEnsemble(s.Family, db)
+	if w, ok := strategy.(weighter); ok {
+		explanation.Weights = make(map[string]map[string]float64)
+		for _, prediction := range aidata.Predictions {
+			explanation.Weights[prediction.Name] = make(map[string]float64)
+			for i, guess := range aidata.Guesses {
+				if i == ExplainGuesses {
+					break
+				}
+				if guess.Location == "?" {
+					continue
+				}
+				explanation.Weights[prediction.Name][guess.Location] = w.Weight(prediction.Name, guess.Location)
+			}
+		}
+	}
+	aidata.Explanation = explanation
+	// the weights explain even when the Go classifier could not
+	if len(explanation.Locations) > 0 {
+		err = nil
+	}
+	return
+}
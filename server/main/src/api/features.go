@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultFeatureSettings are used for families that have not set their own
+var DefaultFeatureSettings = models.FeatureSettings{
+	MinimumDetectionRate: 0.1,
+	MinimumVariance:      1,
+	DropRandomized:       true,
+	Blacklist:            []string{},
+}
+
+// GetFeatureSettings returns the feature selection settings of the family, or the defaults
+func GetFeatureSettings(db *database.Database) (settings models.FeatureSettings) {
+	if err := db.Get("FeatureSettings", &settings); err != nil {
+		settings = DefaultFeatureSettings
+	}
+	return
+}
+
+// SetFeatureSettings saves the feature selection settings, which are used at the next calibration
+func SetFeatureSettings(settings models.FeatureSettings, db *database.Database) (err error) {
+	if settings.MinimumDetectionRate < 0 || settings.MinimumDetectionRate > 1 {
+		err = errors.New("minimum detection rate must be between 0 and 1")
+		return
+	}
+	if settings.MinimumVariance < 0 {
+		err = errors.New("minimum variance cannot be negative")
+		return
+	}
+	err = db.Set("FeatureSettings", settings)
+	return
+}
+
+// GetFeatureSet returns the sensors chosen at the last calibration
+func GetFeatureSet(db *database.Database) (features models.FeatureSet) {
+	db.Get("FeatureSet", &features)
+	return
+}
+
+// selectFeatures chooses the sensors of the learning data, which are saved
+// once the calibration succeeds
+func selectFeatures(family string, datas []models.SensorData, db *database.Database) (features models.FeatureSet) {
+	features = models.SelectFeatures(datas, GetFeatureSettings(db))
+	logger.Log.Infof("[%s] using %d sensors, dropped %d", family, features.NumFeatures(), len(features.Dropped))
+	for feature, reason := range features.Dropped {
+		logger.Log.Debugf("[%s] dropped %s: %s", family, feature, reason)
+	}
+	return
+}
@@ -2,14 +2,17 @@ package api
 
 import (
 	"sync"
+	"time"
 
 	"github.com/schollz/find3/server/main/src/database"
 	"github.com/schollz/find3/server/main/src/models"
 )
 
 type UpdateCounterMap struct {
-	// Data maps family -> counts of locations
+	// Count maps family -> number of learning fingerprints since the last calibration
 	Count map[string]int
+	// Saving maps family -> lock held while saving its count
+	Saving map[string]*sync.Mutex
 	sync.RWMutex
 }
 
@@ -19,6 +22,29 @@ func init() {
 	globalUpdateCounter.Lock()
 	defer globalUpdateCounter.Unlock()
 	globalUpdateCounter.Count = make(map[string]int)
+	globalUpdateCounter.Saving = make(map[string]*sync.Mutex)
+}
+
+// saveNewSamples saves the number of learning fingerprints of the family
+// since the last calibration under its own key, so that counting never
+// rewrites the state of the calibrations. The saves of a family are in
+// order, and each saves the latest count.
+func saveNewSamples(family string, db *database.Database) (err error) {
+	globalUpdateCounter.Lock()
+	saving, ok := globalUpdateCounter.Saving[family]
+	if !ok {
+		saving = new(sync.Mutex)
+		globalUpdateCounter.Saving[family] = saving
+	}
+	globalUpdateCounter.Unlock()
+
+	saving.Lock()
+	defer saving.Unlock()
+	globalUpdateCounter.RLock()
+	count := globalUpdateCounter.Count[family]
+	globalUpdateCounter.RUnlock()
+	err = db.Set("NewSamples", count)
+	return
 }
 
 // SavePrediction will add sensor data to the database
@@ -32,43 +58,37 @@ func SavePrediction(s models.SensorData, p models.LocationAnalysis) (err error)
 	return
 }
 
-/*
-func updateCounter(family string) {
+// CountLearningSample will count a new learning fingerprint for the family and
+// re-calibrate when the policy of the family asks for it
+func CountLearningSample(family string) {
+	db, err := database.Open(family)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	defer db.Close()
+	policy := GetCalibrationPolicy(db)
+	state := GetCalibrationState(db)
+
 	globalUpdateCounter.Lock()
 	if _, ok := globalUpdateCounter.Count[family]; !ok {
-		globalUpdateCounter.Count[family] = 0
+		globalUpdateCounter.Count[family] = state.NewSamples
 	}
 	globalUpdateCounter.Count[family]++
 	count := globalUpdateCounter.Count[family]
 	globalUpdateCounter.Unlock()
 
 	logger.Log.Debugf("'%s' has %d new fingerprints", family, count)
-	if count < 5 {
-		return
+	if err = saveNewSamples(family, db); err != nil {
+		logger.Log.Warn(err)
 	}
-	db, err := database.Open(family)
-	if err != nil {
+	if policy.NewSamples <= 0 || count < policy.NewSamples || IsCalibrating(family) {
 		return
 	}
-	var lastCalibrationTime time.Time
-	err = db.Get("LastCalibrationTime", &lastCalibrationTime)
-	defer db.Close()
-	if err == nil {
-		if time.Since(lastCalibrationTime) < 5*time.Minute {
-			return
-		}
-	}
-	logger.Log.Infof("have %d new fingerprints for '%s', re-calibrating since last calibration was %s", count, family, time.Since(lastCalibrationTime))
-	globalUpdateCounter.Lock()
-	globalUpdateCounter.Count[family] = 0
-	globalUpdateCounter.Unlock()
-
 	// debounce the calibration time
-	err = db.Set("LastCalibrationTime", time.Now().UTC())
-	if err != nil {
-		logger.Log.Error(err)
+	if time.Since(state.LastStart) < time.Duration(policy.DebounceMinutes)*time.Minute {
+		return
 	}
-
-	go Calibrate(family, true)
+	logger.Log.Infof("have %d new fingerprints for '%s', re-calibrating since last calibration was %s", count, family, time.Since(state.LastStart))
+	startScheduledCalibration(family, "new samples")
 }
-*/
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultTransitionPenalty downweights guesses that could not have been reached in time
+const DefaultTransitionPenalty = 0.1
+
+// GetLocationGraph returns the location graph of the family
+func GetLocationGraph(db *database.Database) (g models.LocationGraph, err error) {
+	err = db.Get("LocationGraph", &g)
+	return
+}
+
+// SetLocationGraph validates and saves the location graph of the family
+func SetLocationGraph(g models.LocationGraph, db *database.Database) (err error) {
+	if err = g.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid location graph")
+		return
+	}
+	err = db.Set("LocationGraph", g)
+	return
+}
+
+// ConstrainTransitions will downweight the guesses that the device could not
+// have reached since its previous prediction, according to the location graph.
+// Locations that are not part of the graph are left as they are.
+func ConstrainTransitions(s models.SensorData, aidata *models.LocationAnalysis, db *database.Database) (err error) {
+	if aidata.IsUnknown || len(aidata.Guesses) == 0 {
+		return
+	}
+	g, errGet := GetLocationGraph(db)
+	if errGet != nil || len(g.Locations) == 0 {
+		return
+	}
+
+	previous, err := db.GetLatestBefore(s.Device, s.Timestamp)
+	if err != nil {
+		// nothing to compare against
+		err = nil
+		return
+	}
+	previousGuesses, err := db.GetPrediction(previous.Timestamp)
+	if err != nil || len(previousGuesses) == 0 {
+		err = nil
+		return
+	}
+	previousLocation := previousGuesses[0].Location
+	if !g.HasLocation(previousLocation) {
+		return
+	}
+	elapsed := float64(s.Timestamp-previous.Timestamp) / 1000
+
+	total := float64(0)
+	for i, guess := range aidata.Guesses {
+		if g.HasLocation(guess.Location) {
+			seconds, ok := g.TransitTime(previousLocation, guess.Location)
+			if !ok || seconds > elapsed {
+				logger.Log.Debugf("[%s] %s cannot move from %s to %s in %2.1fs", s.Family, s.Device, previousLocation, guess.Location, elapsed)
+				aidata.Guesses[i].Probability = guess.Probability * g.Penalty
+			}
+		}
+		total += aidata.Guesses[i].Probability
+	}
+
+	// replace with the previous location when no guess is possible
+	if total == 0 {
+		aidata.Guesses = []models.LocationPrediction{
+			{
+				Location:    previousLocation,
+				Probability: 1,
+			},
+		}
+		return
+	}
+	for i := range aidata.Guesses {
+		aidata.Guesses[i].Probability = float64(int(aidata.Guesses[i].Probability/total*100000)) / 100000
+	}
+	sort.SliceStable(aidata.Guesses, func(i, j int) bool {
+		return aidata.Guesses[i].Probability > aidata.Guesses[j].Probability
+	})
+	return
+}
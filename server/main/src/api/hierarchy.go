@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// GetLocationHierarchy returns the location hierarchy of the family
+func GetLocationHierarchy(db *database.Database) (h models.LocationHierarchy, err error) {
+	err = db.Get("LocationHierarchy", &h)
+	return
+}
+
+// SetLocationHierarchy validates and saves the location hierarchy of the
+// family, the models of the levels are learned at the next calibration
+func SetLocationHierarchy(h models.LocationHierarchy, db *database.Database) (err error) {
+	if err = h.Validate(); err != nil {
+		err = errors.Wrap(err, "invalid location hierarchy")
+		return
+	}
+	err = db.Set("LocationHierarchy", h)
+	return
+}
+
+// levelFamily is the name under which the AI learns the model of a level of the hierarchy
+func levelFamily(family string, level string) string {
+	return family + "." + level
+}
+
+// learnHierarchy learns a model for each level of the hierarchy that has
+// more than one location
+func learnHierarchy(ctx context.Context, family string, datas []models.SensorData, offsets map[string]models.DeviceOffset, db *database.Database, job *calibrationJob) (err error) {
+	h, errGet := GetLocationHierarchy(db)
+	if errGet != nil || len(h.Levels) == 0 {
+		return
+	}
+	for i, level := range h.Levels {
+		if len(h.Nodes(i)) < 2 {
+			continue
+		}
+		logger.Log.Debugf("[%s] learning %s level", family, level)
+		if err = learnFromData(ctx, levelFamily(family, level), h.Relabel(datas, i), offsets, job); err != nil {
+			err = errors.Wrap(err, "problem learning "+level+" level")
+			return
+		}
+	}
+	return
+}
+
+// classifyHierarchy guesses the location at each level of the hierarchy,
+// level by level, using the model of each level and the guesses of the rooms
+func classifyHierarchy(ctx context.Context, s models.SensorData, aidata models.LocationAnalysis, db *database.Database) (path []models.LevelGuess, err error) {
+	h, errGet := GetLocationHierarchy(db)
+	if errGet != nil || len(h.Levels) == 0 {
+		return
+	}
+	family := s.Family
+	levelGuesses := make([][]models.LocationPrediction, len(h.Levels))
+	for i, level := range h.Levels {
+		nodes := h.Nodes(i)
+		if len(nodes) == 1 {
+			levelGuesses[i] = []models.LocationPrediction{{Location: nodes[0], Probability: 1}}
+			continue
+		}
+		s.Family = levelFamily(family, level)
+		var levelData models.LocationAnalysis
+		if levelData, err = classifyWithAI(ctx, s); err != nil {
+			err = errors.Wrap(err, "problem classifying "+level+" level")
+			return
+		}
+		levelGuesses[i] = averageStrategy{}.Combine(levelData)
+	}
+	path = h.Descend(levelGuesses, aidata.Guesses)
+	return
+}
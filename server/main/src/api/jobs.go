@@ -0,0 +1,288 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/schollz/find3/server/main/src/utils"
+)
+
+// calibrationJobRetention is how long finished calibration jobs are kept
+const calibrationJobRetention = 24 * time.Hour
+
+// calibrationJob tracks an asynchronous calibration. All of its methods
+// are safe to call on a nil job, for calibrations that are not tracked.
+type calibrationJob struct {
+	status models.CalibrationJob
+	cancel context.CancelFunc
+	done   chan struct{}
+	sync.Mutex
+}
+
+type CalibrationJobs struct {
+	// Jobs maps job ID -> job
+	Jobs map[string]*calibrationJob
+	// Running maps family -> ID of the running job
+	Running map[string]string
+	sync.Mutex
+}
+
+var globalJobs CalibrationJobs
+
+func init() {
+	globalJobs.Lock()
+	defer globalJobs.Unlock()
+	globalJobs.Jobs = make(map[string]*calibrationJob)
+	globalJobs.Running = make(map[string]string)
+}
+
+// StartCalibration will start a calibration job for the family, unless one is already running
+func StartCalibration(family string, trigger string) (status models.CalibrationJob, err error) {
+	globalJobs.Lock()
+	if id, ok := globalJobs.Running[family]; ok {
+		globalJobs.Unlock()
+		err = errors.Errorf("already calibrating %s in job %s", family, id)
+		return
+	}
+	for id, job := range globalJobs.Jobs {
+		finished := job.Status().Finished
+		if finished != nil && time.Since(*finished) > calibrationJobRetention {
+			delete(globalJobs.Jobs, id)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &calibrationJob{
+		status: models.CalibrationJob{
+			ID:      utils.RandomString(8),
+			Family:  family,
+			Trigger: trigger,
+			Phase:   "export",
+			Created: time.Now().UTC(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	globalJobs.Jobs[job.status.ID] = job
+	globalJobs.Running[family] = job.status.ID
+	globalJobs.Unlock()
+
+	go runCalibration(ctx, job)
+	status = job.Status()
+	return
+}
+
+// GetCalibration returns the status of a calibration job
+func GetCalibration(id string) (status models.CalibrationJob, err error) {
+	globalJobs.Lock()
+	job, ok := globalJobs.Jobs[id]
+	globalJobs.Unlock()
+	if !ok {
+		err = errors.New("no calibration job " + id)
+		return
+	}
+	status = job.Status()
+	return
+}
+
+// CancelCalibration will stop a running calibration job
+func CancelCalibration(id string) (status models.CalibrationJob, err error) {
+	globalJobs.Lock()
+	job, ok := globalJobs.Jobs[id]
+	globalJobs.Unlock()
+	if !ok {
+		err = errors.New("no calibration job " + id)
+		return
+	}
+	job.cancel()
+	<-job.done
+	status = job.Status()
+	return
+}
+
+// IsCalibrating returns whether a calibration is running for the family
+func IsCalibrating(family string) bool {
+	globalJobs.Lock()
+	defer globalJobs.Unlock()
+	_, ok := globalJobs.Running[family]
+	return ok
+}
+
+// runCalibration will fit and cross validate the family of the job, keeping
+// the state of the calibration in the database.
+func runCalibration(ctx context.Context, job *calibrationJob) {
+	family := job.Status().Family
+	trigger := job.Status().Trigger
+	var err error
+	defer func() {
+		job.finish(err, ctx.Err() != nil)
+		job.cancel()
+		globalJobs.Lock()
+		delete(globalJobs.Running, family)
+		globalJobs.Unlock()
+		close(job.done)
+	}()
+
+	db, err := database.Open(family)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+
+	state := GetCalibrationState(db)
+	state.Running = true
+	state.Trigger = trigger
+	state.LastStart = time.Now().UTC()
+	if trigger == "nightly" {
+		state.LastNightly = state.LastStart.Format("2006-01-02")
+	}
+	if err = db.Set("CalibrationState", state); err != nil {
+		return
+	}
+	globalUpdateCounter.Lock()
+	globalUpdateCounter.Count[family] = 0
+	globalUpdateCounter.Unlock()
+	if errSave := saveNewSamples(family, db); errSave != nil {
+		logger.Log.Warn(errSave)
+	}
+
+	logger.Log.Infof("[%s] calibrating in job %s (%s)", family, job.Status().ID, trigger)
+	datasTest, err := fitFamily(ctx, family, db, job, true)
+	if err == nil {
+		_, err = findBestAlgorithm(ctx, datasTest, db, job)
+	}
+
+	state.Running = false
+	state.LastFinish = time.Now().UTC()
+	state.LastError = ""
+	if err != nil {
+		state.LastError = err.Error()
+		logger.Log.Warnf("[%s] calibration failed: %s", family, err.Error())
+	}
+	if errSet := db.Set("CalibrationState", state); errSet != nil {
+		logger.Log.Error(errSet)
+	}
+}
+
+// Status returns a copy of the status of the job
+func (j *calibrationJob) Status() (status models.CalibrationJob) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	status = j.status
+	status.WorkerProgress = append([]int{}, j.status.WorkerProgress...)
+	if j.status.Metrics != nil {
+		metrics := *j.status.Metrics
+		status.Metrics = &metrics
+	}
+	return
+}
+
+func (j *calibrationJob) setPhase(phase string) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.status.Phase = phase
+}
+
+func (j *calibrationJob) setSizes(numLearn, numTest int) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Metrics == nil {
+		j.status.Metrics = new(models.CalibrationMetrics)
+	}
+	j.status.Metrics.NumLearn = numLearn
+	j.status.Metrics.NumTest = numTest
+}
+
+func (j *calibrationJob) setFeatures(features models.FeatureSet) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Metrics == nil {
+		j.status.Metrics = new(models.CalibrationMetrics)
+	}
+	j.status.Metrics.NumFeatures = features.NumFeatures()
+	j.status.Metrics.DroppedFeatures = features.Dropped
+}
+
+func (j *calibrationJob) setWorkers(workers int, total int) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.status.WorkerProgress = make([]int, workers)
+	j.status.Total = total
+	j.status.Analyzed = 0
+}
+
+func (j *calibrationJob) workerDone(worker int) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	j.status.WorkerProgress[worker]++
+	j.status.Analyzed++
+}
+
+func (j *calibrationJob) setMetrics(percentCorrect float64, accuracyBreakdown map[string]float64, minimumProbability float64, ensembleAccuracy map[string]float64) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Metrics == nil {
+		j.status.Metrics = new(models.CalibrationMetrics)
+	}
+	j.status.Metrics.PercentCorrect = percentCorrect
+	j.status.Metrics.AccuracyBreakdown = accuracyBreakdown
+	j.status.Metrics.MinimumProbability = minimumProbability
+	j.status.Metrics.EnsembleAccuracy = ensembleAccuracy
+}
+
+func (j *calibrationJob) setPositionError(meanPositionError float64, floorAccuracy float64) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.status.Metrics == nil {
+		j.status.Metrics = new(models.CalibrationMetrics)
+	}
+	j.status.Metrics.MeanPositionError = meanPositionError
+	j.status.Metrics.FloorAccuracy = floorAccuracy
+}
+
+func (j *calibrationJob) finish(err error, cancelled bool) {
+	if j == nil {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	finished := time.Now().UTC()
+	j.status.Finished = &finished
+	if cancelled {
+		j.status.Phase = "cancelled"
+	} else if err != nil {
+		j.status.Phase = "failed"
+	} else {
+		j.status.Phase = "done"
+	}
+	if err != nil {
+		j.status.Error = err.Error()
+	}
+}
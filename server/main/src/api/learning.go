@@ -0,0 +1,215 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultLearningTimeout is how long a learning session lasts if it is not given a timeout
+const DefaultLearningTimeout = 10 * time.Minute
+
+// LearningFlushInterval is how often the learning sessions that changed are saved
+var LearningFlushInterval = 1 * time.Minute
+
+type familyLearning struct {
+	// Sessions maps device -> its latest learning session, nil until loaded
+	Sessions map[string]*models.LearningSession
+	// Changed is set when the sessions were not saved since they changed
+	Changed bool
+	sync.Mutex
+}
+
+type learningSessions struct {
+	// Families maps family -> its learning sessions
+	Families map[string]*familyLearning
+	sync.Mutex
+}
+
+var globalLearning learningSessions
+
+var startLearningFlush sync.Once
+
+func init() {
+	globalLearning.Lock()
+	defer globalLearning.Unlock()
+	globalLearning.Families = make(map[string]*familyLearning)
+}
+
+// getFamilyLearning returns the learning sessions of the family. Lock it
+// before using the sessions.
+func getFamilyLearning(family string) *familyLearning {
+	startLearningFlush.Do(func() {
+		go func() {
+			for {
+				time.Sleep(LearningFlushInterval)
+				FlushLearningSessions()
+			}
+		}()
+	})
+	globalLearning.Lock()
+	defer globalLearning.Unlock()
+	f, ok := globalLearning.Families[family]
+	if !ok {
+		f = new(familyLearning)
+		globalLearning.Families[family] = f
+	}
+	return f
+}
+
+// load loads the sessions of the family if needed. Call it with f locked.
+func (f *familyLearning) load(db *database.Database) {
+	if f.Sessions != nil {
+		return
+	}
+	sessions := make(map[string]*models.LearningSession)
+	if errGet := db.Get("LearningSessions", &sessions); errGet != nil || sessions == nil {
+		sessions = make(map[string]*models.LearningSession)
+	}
+	f.Sessions = sessions
+}
+
+// save saves the sessions of the family. Call it with f locked.
+func (f *familyLearning) save(db *database.Database) (err error) {
+	if err = db.Set("LearningSessions", f.Sessions); err == nil {
+		f.Changed = false
+	}
+	return
+}
+
+// StartLearningSession saves the next tracking fingerprints of the device as
+// learning data for the location, until there are target of them or the
+// session times out
+func StartLearningSession(family string, device string, location string, target int, timeout time.Duration, db *database.Database) (session models.LearningSession, err error) {
+	if timeout == 0 {
+		timeout = DefaultLearningTimeout
+	}
+	now := time.Now().UTC()
+	session, err = models.NewLearningSession(device, location, target, timeout, now)
+	if err != nil {
+		return
+	}
+
+	f := getFamilyLearning(family)
+	f.Lock()
+	defer f.Unlock()
+	f.load(db)
+	if current, ok := f.Sessions[device]; ok && current.Active(now) {
+		err = errors.Errorf("%s is already learning %s", device, current.Location)
+		return
+	}
+	started := session
+	f.Sessions[device] = &started
+	if err = f.save(db); err != nil {
+		return
+	}
+	logger.Log.Debugf("[%s] learning %d fingerprints of %s at %s", family, target, device, location)
+	return
+}
+
+// GetLearningSession returns the progress of the latest learning session of the device
+func GetLearningSession(family string, device string, db *database.Database) (session models.LearningSession, err error) {
+	f := getFamilyLearning(family)
+	f.Lock()
+	defer f.Unlock()
+	f.load(db)
+	current, ok := f.Sessions[device]
+	if !ok {
+		err = errors.Errorf("no learning session for %s", device)
+		return
+	}
+	if current.Status == "learning" && !current.Active(time.Now().UTC()) {
+		// saved every LearningFlushInterval
+		f.Changed = true
+	}
+	session = *current
+	return
+}
+
+// StopLearningSession stops the learning session of the device before it is done
+func StopLearningSession(family string, device string, db *database.Database) (session models.LearningSession, err error) {
+	f := getFamilyLearning(family)
+	f.Lock()
+	defer f.Unlock()
+	f.load(db)
+	current, ok := f.Sessions[device]
+	if !ok || current.Status != "learning" {
+		err = errors.Errorf("%s is not learning", device)
+		return
+	}
+	current.Stop(time.Now().UTC())
+	if err = f.save(db); err != nil {
+		return
+	}
+	session = *current
+	return
+}
+
+// LearnInSession sets the location of tracking data to that of the learning
+// session of its device, if there is one, so that it is saved as learning
+// data. It returns whether the data was taken by a session. The progress of
+// the sessions is saved every LearningFlushInterval.
+func LearnInSession(s *models.SensorData) (learning bool) {
+	if s.Location != "" {
+		return
+	}
+	f := getFamilyLearning(s.Family)
+	f.Lock()
+	defer f.Unlock()
+	if f.Sessions == nil {
+		db, err := database.Open(s.Family)
+		if err != nil {
+			logger.Log.Warn(err)
+			return
+		}
+		f.load(db)
+		db.Close()
+	}
+	current, ok := f.Sessions[s.Device]
+	if !ok || current.Status != "learning" {
+		return
+	}
+	learning = current.Add(time.Now().UTC())
+	if learning {
+		s.Location = current.Location
+		logger.Log.Debugf("[%s] learned %d/%d fingerprints of %s at %s", s.Family, current.Collected, current.Target, s.Device, s.Location)
+	}
+	if current.Status != "learning" {
+		logger.Log.Infof("[%s] learning session of %s at %s is %s", s.Family, s.Device, current.Location, current.Status)
+	}
+	f.Changed = true
+	return
+}
+
+// FlushLearningSessions saves the learning sessions that changed
+func FlushLearningSessions() {
+	globalLearning.Lock()
+	families := make(map[string]*familyLearning, len(globalLearning.Families))
+	for family, f := range globalLearning.Families {
+		families[family] = f
+	}
+	globalLearning.Unlock()
+
+	for family, f := range families {
+		f.Lock()
+		if !f.Changed || f.Sessions == nil {
+			f.Unlock()
+			continue
+		}
+		err := func() (err error) {
+			db, err := database.Open(family)
+			if err != nil {
+				return
+			}
+			defer db.Close()
+			return f.save(db)
+		}()
+		if err != nil {
+			logger.Log.Warnf("[%s] problem saving learning sessions: %s", family, err.Error())
+		}
+		f.Unlock()
+	}
+}
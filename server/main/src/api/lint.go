@@ -0,0 +1,30 @@
+package api
+
+import (
+	"time"
+
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// lintRecentWindow is how far back the tracking data is checked for beacons that vanished
+const lintRecentWindow = 24 * time.Hour
+
+// LintFamily checks the learning data of the family for problems
+func LintFamily(db *database.Database) (report models.LintReport, err error) {
+	datas, err := db.GetAllForClassification()
+	if err != nil {
+		return
+	}
+	report = lintData(datas, db)
+	return
+}
+
+func lintData(datas []models.SensorData, db *database.Database) models.LintReport {
+	since := time.Now().Add(-lintRecentWindow).UnixNano() / int64(time.Millisecond)
+	recent, err := db.GetTrackingSince(since)
+	if err != nil {
+		logger.Log.Debugf("could not get recent tracking data: %s", err.Error())
+	}
+	return models.Lint(datas, recent)
+}
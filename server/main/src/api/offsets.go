@@ -0,0 +1,73 @@
+package api
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// GetDeviceOffsets returns the signal strength corrections of each device in the family
+func GetDeviceOffsets(db *database.Database) (offsets map[string]models.DeviceOffset) {
+	if err := db.Get("DeviceOffsets", &offsets); err != nil || offsets == nil {
+		offsets = make(map[string]models.DeviceOffset)
+	}
+	return
+}
+
+// ResetDeviceOffsets removes the correction of a device, or of all devices
+// when no device is given. If pin is set, the devices are pinned to no
+// correction, which the calibrations keep. Otherwise the next calibration
+// estimates them again.
+func ResetDeviceOffsets(db *database.Database, pin bool, device ...string) (err error) {
+	offsets := GetDeviceOffsets(db)
+	devices := device
+	if len(device) > 0 {
+		if _, ok := offsets[device[0]]; !ok {
+			err = errors.New("no offset for " + device[0])
+			return
+		}
+	} else {
+		for d := range offsets {
+			devices = append(devices, d)
+		}
+	}
+	for _, d := range devices {
+		if pin {
+			offsets[d] = models.DeviceOffset{Scale: 1, Updated: time.Now().UTC(), Pinned: true}
+		} else {
+			delete(offsets, d)
+		}
+	}
+	err = db.Set("DeviceOffsets", offsets)
+	return
+}
+
+// estimateDeviceOffsets estimates the corrections of the devices from the
+// learning data, which are saved once the calibration succeeds. The pinned
+// corrections are kept.
+func estimateDeviceOffsets(family string, datas []models.SensorData, db *database.Database) (offsets map[string]models.DeviceOffset) {
+	offsets = models.EstimateDeviceOffsets(datas)
+	for device, offset := range GetDeviceOffsets(db) {
+		if offset.Pinned {
+			offsets[device] = offset
+		}
+	}
+	for device, offset := range offsets {
+		logger.Log.Debugf("[%s] %s offset: %2.3f*rssi%+2.2f from %d readings (pinned %v)", family, device, offset.Scale, offset.Offset, offset.Samples, offset.Pinned)
+	}
+	return
+}
+
+// applyOffsets returns copies of the sensor data with the signal strengths of their devices corrected
+func applyOffsets(datas []models.SensorData, offsets map[string]models.DeviceOffset) (corrected []models.SensorData) {
+	corrected = make([]models.SensorData, len(datas))
+	for i, data := range datas {
+		if offset, ok := offsets[data.Device]; ok {
+			data = offset.Apply(data)
+		}
+		corrected[i] = data
+	}
+	return
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mr-tron/base58/base58"
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultReplaySplit is the fraction of the learning data, by time, that is
+// learned when there is no separate test file
+const DefaultReplaySplit = 0.7
+
+// ReplayOptions configure an offline evaluation of recorded data
+type ReplayOptions struct {
+	// Family is the scratch family that is calibrated. It must not have a
+	// database, and its database and models are removed when done.
+	Family string
+	// LearnFile is the learning data, as written by Dump
+	LearnFile string
+	// TestFile is the data that is classified. If empty, the newest part of
+	// the learning data is used.
+	TestFile string
+	// Split is the fraction of the learning data used for learning when there is no test file
+	Split float64
+}
+
+// ReplayReport is the result of an offline evaluation
+type ReplayReport struct {
+	Family   string `json:"family"`
+	NumLearn int    `json:"num_learn"`
+	// NumTest is the number of fingerprints classified
+	NumTest int `json:"num_test"`
+	// NumLabeled is the number of classified fingerprints with a location
+	NumLabeled int `json:"num_labeled"`
+	// Accuracy is the fraction of the labeled fingerprints that were guessed correctly
+	Accuracy  float64                `json:"accuracy"`
+	Unknown   int                    `json:"unknown"`
+	Errors    int                    `json:"errors"`
+	Confusion models.ConfusionMatrix `json:"confusion"`
+	// Latency maps percentile -> milliseconds to classify a fingerprint
+	Latency map[string]float64 `json:"latency"`
+}
+
+// Replay will calibrate a scratch family on recorded learning data, then
+// classify the recorded test data the same way the server does. The scratch
+// family is created for the replay and removed afterwards, so a family that
+// already exists is refused.
+func Replay(opts ReplayOptions) (report ReplayReport, err error) {
+	defer logger.Log.Flush()
+	opts.Family = strings.ToLower(strings.TrimSpace(opts.Family))
+	if opts.Family == "" {
+		err = errors.New("need a scratch family for replaying")
+		return
+	}
+	if opts.Split <= 0 || opts.Split >= 1 {
+		opts.Split = DefaultReplaySplit
+	}
+
+	datasLearn, err := readDatas(opts.LearnFile)
+	if err != nil {
+		return
+	}
+	var datasTest []models.SensorData
+	if opts.TestFile != "" {
+		if datasTest, err = readDatas(opts.TestFile); err != nil {
+			return
+		}
+	} else {
+		sort.Slice(datasLearn, func(i, j int) bool { return datasLearn[i].Timestamp < datasLearn[j].Timestamp })
+		split := int(float64(len(datasLearn)) * opts.Split)
+		datasLearn, datasTest = datasLearn[:split], datasLearn[split:]
+	}
+	if len(datasTest) == 0 {
+		err = errors.New("no data to classify")
+		return
+	}
+	for i := range datasLearn {
+		datasLearn[i].Family = opts.Family
+	}
+	for i := range datasTest {
+		datasTest[i].Family = opts.Family
+	}
+
+	exists, err := database.Exists(opts.Family)
+	if err != nil {
+		return
+	}
+	if exists {
+		err = errors.Errorf("family %s already exists, replay needs a new scratch family", opts.Family)
+		return
+	}
+	if err = database.Create(opts.Family); err != nil {
+		err = errors.Wrap(err, "problem creating database of "+opts.Family)
+		return
+	}
+	defer removeScratchFamily(opts.Family)
+	db, err := database.Open(opts.Family)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+
+	// calibrate the same way as the server, but on the recorded data
+	logger.Log.Infof("[%s] replay calibrating on %d fingerprints", opts.Family, len(datasLearn))
+	datasCrossValidation, err := fitDatas(context.Background(), opts.Family, datasLearn, db, nil, true)
+	if err != nil {
+		err = errors.Wrap(err, "problem calibrating")
+		return
+	}
+	if _, err = findBestAlgorithm(context.Background(), datasCrossValidation, db, nil); err != nil {
+		err = errors.Wrap(err, "problem finding best algorithm")
+		return
+	}
+
+	report.Family = opts.Family
+	report.NumLearn = len(datasLearn)
+	report.NumTest = len(datasTest)
+	report.Confusion = make(models.ConfusionMatrix)
+	latencies := make([]float64, 0, len(datasTest))
+	correct := 0
+	for _, s := range datasTest {
+		t := time.Now()
+		aidata, errAnalyze := AnalyzeSensorData(s, db)
+		latencies = append(latencies, float64(time.Since(t))/float64(time.Millisecond))
+		if errAnalyze != nil || len(aidata.Guesses) == 0 {
+			report.Errors++
+			continue
+		}
+		guess := aidata.Guesses[0].Location
+		if guess == "?" {
+			report.Unknown++
+		}
+		if s.Location == "" {
+			continue
+		}
+		report.NumLabeled++
+		if _, ok := report.Confusion[s.Location]; !ok {
+			report.Confusion[s.Location] = make(map[string]int)
+		}
+		report.Confusion[s.Location][guess]++
+		if guess == s.Location {
+			correct++
+		}
+	}
+	if report.NumLabeled > 0 {
+		report.Accuracy = float64(correct) / float64(report.NumLabeled)
+	}
+
+	sort.Float64s(latencies)
+	report.Latency = make(map[string]float64)
+	for _, p := range []float64{50, 90, 99} {
+		report.Latency[fmt.Sprintf("p%d", int(p))] = percentile(latencies, p)
+	}
+	return
+}
+
+// String formats the report as text
+func (r ReplayReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "family:    %s\n", r.Family)
+	fmt.Fprintf(&b, "learned:   %d\n", r.NumLearn)
+	fmt.Fprintf(&b, "tested:    %d (%d labeled, %d unknown, %d errors)\n", r.NumTest, r.NumLabeled, r.Unknown, r.Errors)
+	fmt.Fprintf(&b, "accuracy:  %2.1f%%\n", r.Accuracy*100)
+	fmt.Fprintf(&b, "latency:   p50 %2.1f ms, p90 %2.1f ms, p99 %2.1f ms\n", r.Latency["p50"], r.Latency["p90"], r.Latency["p99"])
+
+	locations := []string{}
+	guessed := make(map[string]struct{})
+	for location := range r.Confusion {
+		locations = append(locations, location)
+		guessed[location] = struct{}{}
+		for guess := range r.Confusion[location] {
+			guessed[guess] = struct{}{}
+		}
+	}
+	sort.Strings(locations)
+	columns := []string{}
+	for guess := range guessed {
+		columns = append(columns, guess)
+	}
+	sort.Strings(columns)
+
+	b.WriteString("\nconfusion (rows are true locations, columns are guesses):\n")
+	fmt.Fprintf(&b, "%-16s", "")
+	for _, column := range columns {
+		fmt.Fprintf(&b, " %12.12s", column)
+	}
+	b.WriteString("\n")
+	for _, location := range locations {
+		fmt.Fprintf(&b, "%-16.16s", location)
+		for _, column := range columns {
+			fmt.Fprintf(&b, " %12d", r.Confusion[location][column])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// removeScratchFamily drops the database of a scratch family and the models
+// that the AI server keeps in the data folder
+func removeScratchFamily(family string) {
+	if err := database.Drop(family); err != nil {
+		logger.Log.Warnf("[%s] problem dropping scratch database: %s", family, err.Error())
+	}
+	for _, suffix := range []string{".find3.ai", ".find3.version"} {
+		if err := os.Remove(path.Join(DataFolder, family+suffix)); err != nil && !os.IsNotExist(err) {
+			logger.Log.Warnf("[%s] problem removing scratch model: %s", family, err.Error())
+		}
+	}
+	os.RemoveAll(path.Join(DataFolder, "images", base58.FastBase58Encoding([]byte(family))))
+}
+
+// readDatas reads the sensor data written by Dump, one JSON object per line
+func readDatas(fname string) (datas []models.SensorData, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var s models.SensorData
+		if err = json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			err = errors.Wrapf(err, "%s:%d", fname, line)
+			return
+		}
+		datas = append(datas, s)
+	}
+	err = scanner.Err()
+	return
+}
+
+// percentile returns the p-th percentile of sorted values, using the nearest rank
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultCalibrationPolicy is used for families that have not set their own,
+// it only debounces and never calibrates by itself
+var DefaultCalibrationPolicy = models.CalibrationPolicy{
+	DebounceMinutes: 5,
+}
+
+// schedulerInterval is how often the scheduler checks the nightly calibrations
+const schedulerInterval = 1 * time.Minute
+
+type CalibrationScheduler struct {
+	// Families are the families checked for nightly calibration
+	Families map[string]struct{}
+	sync.Mutex
+}
+
+var globalScheduler CalibrationScheduler
+
+func init() {
+	globalScheduler.Lock()
+	defer globalScheduler.Unlock()
+	globalScheduler.Families = make(map[string]struct{})
+}
+
+// StartScheduler will start checking the calibration policies of the
+// families that have saved one, and of the given families
+func StartScheduler(families ...string) {
+	// given maps family -> whether it is checked without a saved policy
+	given := make(map[string]bool)
+	listed, err := database.Families()
+	if err != nil {
+		logger.Log.Warnf("could not list the families: %s", err.Error())
+	}
+	for _, family := range listed {
+		given[family] = false
+	}
+	for _, family := range families {
+		given[family] = true
+	}
+
+	checked := 0
+	for family, always := range given {
+		db, err := database.Open(family)
+		if err != nil {
+			logger.Log.Warn(err)
+			continue
+		}
+		var policy models.CalibrationPolicy
+		if errGet := db.Get("CalibrationPolicy", &policy); errGet == nil || always {
+			globalScheduler.Lock()
+			globalScheduler.Families[family] = struct{}{}
+			globalScheduler.Unlock()
+			checked++
+		}
+
+		// a calibration that was running when the server stopped will never finish
+		state := GetCalibrationState(db)
+		if state.Running {
+			state.Running = false
+			state.LastError = "interrupted by restart"
+			if err = db.Set("CalibrationState", state); err != nil {
+				logger.Log.Warn(err)
+			}
+		}
+		db.Close()
+	}
+	logger.Log.Infof("checking the calibration policies of %d families", checked)
+
+	go func() {
+		for {
+			time.Sleep(schedulerInterval)
+			checkNightlyCalibrations(time.Now().UTC())
+		}
+	}()
+}
+
+// GetCalibrationPolicy returns the calibration policy of the family, or the defaults
+func GetCalibrationPolicy(db *database.Database) (policy models.CalibrationPolicy) {
+	if err := db.Get("CalibrationPolicy", &policy); err != nil {
+		policy = DefaultCalibrationPolicy
+	}
+	return
+}
+
+// SetCalibrationPolicy validates and saves the calibration policy of the family
+func SetCalibrationPolicy(family string, policy models.CalibrationPolicy, db *database.Database) (err error) {
+	if policy.NewSamples < 0 || policy.DriftThreshold < 0 || policy.DriftAlert < 0 || policy.DebounceMinutes < 0 {
+		err = errors.New("policy values cannot be negative")
+		return
+	}
+	if policy.Nightly != "" {
+		if _, err = time.Parse("15:04", policy.Nightly); err != nil {
+			err = errors.Wrap(err, "nightly time must be formatted like 03:00")
+			return
+		}
+	}
+	if err = db.Set("CalibrationPolicy", policy); err != nil {
+		return
+	}
+	globalScheduler.Lock()
+	globalScheduler.Families[family] = struct{}{}
+	globalScheduler.Unlock()
+	return
+}
+
+// GetCalibrationState returns the persisted calibration state of the family
+func GetCalibrationState(db *database.Database) (state models.CalibrationState) {
+	db.Get("CalibrationState", &state)
+	// the new samples are counted under their own key
+	var newSamples int
+	if err := db.Get("NewSamples", &newSamples); err == nil {
+		state.NewSamples = newSamples
+	}
+	return
+}
+
+// NotifyDrift will recalibrate the family if the drift exceeds the threshold of its policy
+func NotifyDrift(family string, score float64) {
+	db, err := database.Open(family)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	policy := GetCalibrationPolicy(db)
+	state := GetCalibrationState(db)
+	db.Close()
+	if policy.DriftThreshold <= 0 || score < policy.DriftThreshold {
+		return
+	}
+	if time.Since(state.LastStart) < time.Duration(policy.DebounceMinutes)*time.Minute {
+		return
+	}
+	logger.Log.Infof("[%s] drift of %2.2f exceeds %2.2f, re-calibrating", family, score, policy.DriftThreshold)
+	startScheduledCalibration(family, "drift")
+}
+
+func checkNightlyCalibrations(now time.Time) {
+	globalScheduler.Lock()
+	families := make([]string, 0, len(globalScheduler.Families))
+	for family := range globalScheduler.Families {
+		families = append(families, family)
+	}
+	globalScheduler.Unlock()
+
+	today := now.Format("2006-01-02")
+	for _, family := range families {
+		db, err := database.Open(family)
+		if err != nil {
+			logger.Log.Warn(err)
+			continue
+		}
+		policy := GetCalibrationPolicy(db)
+		state := GetCalibrationState(db)
+		db.Close()
+		if policy.Nightly == "" || state.LastNightly == today {
+			continue
+		}
+		nightly, err := time.Parse("15:04", policy.Nightly)
+		if err != nil {
+			continue
+		}
+		if now.Hour()*60+now.Minute() < nightly.Hour()*60+nightly.Minute() {
+			continue
+		}
+		logger.Log.Infof("[%s] running nightly calibration", family)
+		startScheduledCalibration(family, "nightly")
+	}
+}
+
+// startScheduledCalibration will start a calibration job and log when it cannot
+func startScheduledCalibration(family string, trigger string) {
+	if _, err := StartCalibration(family, trigger); err != nil {
+		logger.Log.Warnf("[%s] could not start %s calibration: %s", family, trigger, err.Error())
+	}
+}
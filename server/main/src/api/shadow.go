@@ -0,0 +1,475 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/schollz/find3/server/main/src/utils"
+)
+
+// candidateSettingKeys are the settings of the live model that a candidate
+// starts from, before its own settings are applied
+var candidateSettingKeys = []string{
+	"LocationCoordinates", "LocationHierarchy", "CalibrationPolicy",
+	"FeatureSettings", "EnsembleStrategy", "Algorithms",
+}
+
+// candidateModelKeys are the settings and the calibration of a candidate
+// that replace those of the live model when it is promoted
+var candidateModelKeys = []string{
+	"FeatureSettings", "EnsembleStrategy", "Algorithms",
+	"DeviceOffsets", "DriftBaseline", "FeatureSet", "NB1", "NB1Counts",
+	"ProbabilityMeans", "ProbabilitiesOfBestGuess", "MinimumProbability",
+	"PercentCorrect", "AccuracyBreakdown", "MeanPositionError", "FloorAccuracy",
+	"EnsembleConfusion", "BestGuessProbabilities", "PredictionAnalysis",
+	"AlgorithmEfficacy", "BestAlgorithm", "StackedModel", "EnsembleAccuracy",
+	"LastCalibrationTime", "ModelFamily",
+}
+
+// ShadowQueueSize is the number of fingerprints that can wait to be compared
+// with a candidate model. Fingerprints that arrive when it is full are not
+// compared, so that candidates never slow down the live model.
+var ShadowQueueSize = 100
+
+// ShadowWorkers is the number of fingerprints that are compared with
+// candidate models at once
+var ShadowWorkers = 2
+
+// ShadowFlushInterval is how often the shadow reports that changed are saved
+var ShadowFlushInterval = 1 * time.Minute
+
+type shadowReports struct {
+	// Reports maps family -> the report of its candidate, nil if it has none
+	Reports map[string]*models.ShadowReport
+	// Changed is the families whose report was not saved since it changed
+	Changed map[string]bool
+	// Saving maps family -> lock held while saving its report
+	Saving map[string]*sync.Mutex
+	sync.Mutex
+}
+
+var globalShadows shadowReports
+
+// shadowObservation is a fingerprint waiting to be compared with a candidate
+type shadowObservation struct {
+	s models.SensorData
+	// live is the prediction of the live model, nil for learning data
+	live *models.LocationPrediction
+}
+
+var shadowQueue chan shadowObservation
+
+var startShadowWorkers sync.Once
+
+func init() {
+	globalShadows.Lock()
+	defer globalShadows.Unlock()
+	globalShadows.Reports = make(map[string]*models.ShadowReport)
+	globalShadows.Changed = make(map[string]bool)
+	globalShadows.Saving = make(map[string]*sync.Mutex)
+}
+
+// shadowFamily is the family whose database keeps the candidate model of a family
+func shadowFamily(family string) string {
+	return family + "_shadow"
+}
+
+// getShadowReport returns the report of the candidate of the family, loading
+// it if needed, or nil if there is none. Call it with globalShadows locked.
+func getShadowReport(family string, db *database.Database) *models.ShadowReport {
+	report, ok := globalShadows.Reports[family]
+	if ok {
+		return report
+	}
+	report = new(models.ShadowReport)
+	if errGet := db.Get("ShadowReport", report); errGet != nil {
+		report = nil
+	}
+	globalShadows.Reports[family] = report
+	return report
+}
+
+// copyShadowReport copies a report, as the report of a candidate keeps changing
+func copyShadowReport(current *models.ShadowReport) (report models.ShadowReport) {
+	report = *current
+	report.Recent = append([]models.ShadowPrediction{}, current.Recent...)
+	return
+}
+
+// saveShadowReport saves the report of the candidate of the family. The
+// saves of a family are in order, and each saves the latest report.
+func saveShadowReport(family string, db *database.Database) (err error) {
+	globalShadows.Lock()
+	saving, ok := globalShadows.Saving[family]
+	if !ok {
+		saving = new(sync.Mutex)
+		globalShadows.Saving[family] = saving
+	}
+	globalShadows.Unlock()
+
+	saving.Lock()
+	defer saving.Unlock()
+	globalShadows.Lock()
+	current := globalShadows.Reports[family]
+	if current == nil {
+		globalShadows.Unlock()
+		return
+	}
+	report := copyShadowReport(current)
+	delete(globalShadows.Changed, family)
+	globalShadows.Unlock()
+
+	if err = db.Set("ShadowReport", report); err != nil {
+		globalShadows.Lock()
+		globalShadows.Changed[family] = true
+		globalShadows.Unlock()
+	}
+	return
+}
+
+// FlushShadows saves the shadow reports that changed
+func FlushShadows() {
+	globalShadows.Lock()
+	var families []string
+	for family := range globalShadows.Changed {
+		families = append(families, family)
+	}
+	globalShadows.Unlock()
+
+	for _, family := range families {
+		err := func() (err error) {
+			db, err := database.Open(family)
+			if err != nil {
+				return
+			}
+			defer db.Close()
+			return saveShadowReport(family, db)
+		}()
+		if err != nil {
+			logger.Log.Warnf("[%s] problem saving shadow report: %s", family, err.Error())
+		}
+	}
+}
+
+// copyKeys copies the values of the keys that are set in one database to another
+func copyKeys(from *database.Database, to *database.Database, keys []string) (err error) {
+	values := make(map[string]*json.RawMessage)
+	keyValues := make(map[string]interface{})
+	for _, key := range keys {
+		values[key] = new(json.RawMessage)
+		keyValues[key] = values[key]
+	}
+	if err = from.GetMany(keyValues); err != nil {
+		return
+	}
+	keyValues = make(map[string]interface{})
+	for key, value := range values {
+		if len(*value) > 0 {
+			keyValues[key] = value
+		}
+	}
+	err = to.SetMany(keyValues)
+	return
+}
+
+// StartShadow calibrates a candidate model for the family, which then
+// classifies the live data next to the live model without answering
+func StartShadow(family string, settings models.CandidateSettings, db *database.Database) (report models.ShadowReport, err error) {
+	report, err = func() (report models.ShadowReport, err error) {
+		globalShadows.Lock()
+		defer globalShadows.Unlock()
+		if current := getShadowReport(family, db); current != nil && current.Status == "calibrating" {
+			err = errors.Errorf("already calibrating candidate %s", current.ID)
+			return
+		}
+
+		if err = database.Create(shadowFamily(family)); err != nil {
+			err = errors.Wrap(err, "problem creating database of candidate")
+			return
+		}
+		sdb, err := database.Open(shadowFamily(family))
+		if err != nil {
+			return
+		}
+		defer sdb.Close()
+		if err = copyKeys(db, sdb, candidateSettingKeys); err != nil {
+			err = errors.Wrap(err, "problem copying settings")
+			return
+		}
+		if settings.FeatureSettings != nil {
+			if err = SetFeatureSettings(*settings.FeatureSettings, sdb); err != nil {
+				return
+			}
+		}
+		if settings.EnsembleStrategy != "" {
+			if err = SetEnsembleStrategy(settings.EnsembleStrategy, sdb); err != nil {
+				return
+			}
+		}
+		if len(settings.Algorithms) > 0 {
+			if err = sdb.Set("Algorithms", settings.Algorithms); err != nil {
+				return
+			}
+		}
+
+		id := strings.ToLower(utils.RandomString(8))
+		report = models.ShadowReport{
+			ID: id,
+			// the candidate models have their own name, so that promoting them
+			// does not let the next candidate overwrite them
+			Model:    shadowFamily(family) + "." + id,
+			Settings: settings,
+			Status:   "calibrating",
+			Created:  time.Now().UTC(),
+			Recent:   []models.ShadowPrediction{},
+		}
+		current := report
+		globalShadows.Reports[family] = &current
+		return
+	}()
+	if err != nil {
+		return
+	}
+	if err = saveShadowReport(family, db); err != nil {
+		return
+	}
+	go calibrateShadow(family, report.ID, report.Model)
+	return
+}
+
+// calibrateShadow calibrates the candidate on the learning data of the family
+func calibrateShadow(family string, id string, model string) {
+	err := func() (err error) {
+		db, err := database.Open(family)
+		if err != nil {
+			return
+		}
+		defer db.Close()
+		sdb, err := database.Open(shadowFamily(family))
+		if err != nil {
+			return
+		}
+		defer sdb.Close()
+
+		datas, err := db.GetAllForClassification()
+		if err != nil {
+			return
+		}
+		for i := range datas {
+			datas[i].Family = shadowFamily(family)
+		}
+		logger.Log.Infof("[%s] calibrating candidate %s on %d fingerprints", family, id, len(datas))
+		datasTest, err := fitDatas(context.Background(), model, datas, sdb, nil, true)
+		if err != nil {
+			return
+		}
+		_, err = findBestAlgorithm(context.Background(), datasTest, sdb, nil)
+		return
+	}()
+
+	db, errOpen := database.Open(family)
+	if errOpen != nil {
+		logger.Log.Warn(errOpen)
+		return
+	}
+	defer db.Close()
+	globalShadows.Lock()
+	report := getShadowReport(family, db)
+	if report == nil || report.ID != id || report.Status != "calibrating" {
+		globalShadows.Unlock()
+		return
+	}
+	report.Status = "shadowing"
+	if err != nil {
+		report.Status = "failed"
+		report.Error = err.Error()
+		logger.Log.Warnf("[%s] problem calibrating candidate %s: %s", family, id, err.Error())
+	}
+	globalShadows.Unlock()
+	if errSave := saveShadowReport(family, db); errSave != nil {
+		logger.Log.Error(errSave)
+	}
+}
+
+// ObserveShadow queues tracking data to be classified with the candidate of
+// its family, if there is one, and compared with the best guess of the live model
+func ObserveShadow(s models.SensorData, live models.LocationPrediction) {
+	queueShadow(s, &live)
+}
+
+// ObserveShadowLearning queues learning data to be classified with the live
+// model and the candidate of its family, if there is one, and compared with
+// its location
+func ObserveShadowLearning(s models.SensorData) {
+	queueShadow(s, nil)
+}
+
+// queueShadow queues the data for the workers that compare it with the
+// candidate of its family, unless the queue is full
+func queueShadow(s models.SensorData, live *models.LocationPrediction) {
+	globalShadows.Lock()
+	report, loaded := globalShadows.Reports[s.Family]
+	shadowing := report != nil && report.Status == "shadowing"
+	globalShadows.Unlock()
+	if loaded && !shadowing {
+		return
+	}
+
+	startShadowWorkers.Do(func() {
+		shadowQueue = make(chan shadowObservation, ShadowQueueSize)
+		for i := 0; i < ShadowWorkers; i++ {
+			go func() {
+				for o := range shadowQueue {
+					observeShadow(o.s, o.live)
+				}
+			}()
+		}
+		go func() {
+			for {
+				time.Sleep(ShadowFlushInterval)
+				FlushShadows()
+			}
+		}()
+	})
+	select {
+	case shadowQueue <- shadowObservation{s: s, live: live}:
+	default:
+		logger.Log.Debugf("[%s] too many fingerprints waiting for the candidate, skipping one", s.Family)
+	}
+}
+
+func observeShadow(s models.SensorData, live *models.LocationPrediction) {
+	db, err := database.Open(s.Family)
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	defer db.Close()
+
+	globalShadows.Lock()
+	report := getShadowReport(s.Family, db)
+	if report == nil || report.Status != "shadowing" {
+		globalShadows.Unlock()
+		return
+	}
+	id := report.ID
+	globalShadows.Unlock()
+
+	if live == nil {
+		liveData, errAnalyze := AnalyzeSensorData(s, db)
+		if errAnalyze != nil || len(liveData.Guesses) == 0 {
+			return
+		}
+		live = &liveData.Guesses[0]
+	}
+	sdb, err := database.Open(shadowFamily(s.Family))
+	if err != nil {
+		logger.Log.Warn(err)
+		return
+	}
+	defer sdb.Close()
+	candidate := s
+	candidate.Family = shadowFamily(s.Family)
+	candidateData, err := AnalyzeSensorData(candidate, sdb)
+	if err != nil || len(candidateData.Guesses) == 0 {
+		logger.Log.Debugf("[%s] candidate %s could not classify", s.Family, id)
+		return
+	}
+
+	p := models.ShadowPrediction{
+		Timestamp: s.Timestamp,
+		Device:    s.Device,
+		Location:  s.Location,
+		Live:      live.Location,
+		Candidate: candidateData.Guesses[0].Location,
+	}
+	logger.Log.Debugf("[%s] candidate %s guessed %s, live guessed %s", s.Family, id, p.Candidate, p.Live)
+
+	globalShadows.Lock()
+	defer globalShadows.Unlock()
+	report = getShadowReport(s.Family, db)
+	if report == nil || report.ID != id || report.Status != "shadowing" {
+		return
+	}
+	report.Add(p)
+	// saved every ShadowFlushInterval
+	globalShadows.Changed[s.Family] = true
+}
+
+// GetShadowReport returns how the candidate of the family compares with the live model
+func GetShadowReport(family string, db *database.Database) (report models.ShadowReport, err error) {
+	globalShadows.Lock()
+	defer globalShadows.Unlock()
+	current := getShadowReport(family, db)
+	if current == nil {
+		err = errors.New("no candidate, need to start one")
+		return
+	}
+	report = copyShadowReport(current)
+	return
+}
+
+// StopShadow stops classifying with the candidate of the family
+func StopShadow(family string, db *database.Database) (report models.ShadowReport, err error) {
+	globalShadows.Lock()
+	current := getShadowReport(family, db)
+	if current == nil || current.Status != "shadowing" {
+		globalShadows.Unlock()
+		err = errors.New("no calibrated candidate to stop")
+		return
+	}
+	current.Status = "stopped"
+	report = copyShadowReport(current)
+	globalShadows.Unlock()
+	err = saveShadowReport(family, db)
+	return
+}
+
+// PromoteShadow replaces the live model of the family with its candidate.
+// The settings and calibration of the candidate are saved in one transaction,
+// and the family classifies with the models of the candidate from then on.
+func PromoteShadow(family string, db *database.Database) (report models.ShadowReport, err error) {
+	globalShadows.Lock()
+	defer func() {
+		if err == nil {
+			if errSave := saveShadowReport(family, db); errSave != nil {
+				logger.Log.Error(errSave)
+			}
+		}
+	}()
+	defer globalShadows.Unlock()
+	current := getShadowReport(family, db)
+	if current == nil || current.Status != "shadowing" {
+		err = errors.New("no calibrated candidate to promote")
+		return
+	}
+
+	sdb, err := database.Open(shadowFamily(family))
+	if err != nil {
+		return
+	}
+	defer sdb.Close()
+	if err = copyKeys(sdb, db, candidateModelKeys); err != nil {
+		err = errors.Wrap(err, "problem promoting candidate")
+		return
+	}
+	logger.Log.Infof("[%s] promoted candidate %s (agreement %2.0f%%, accuracy %2.0f%% vs %2.0f%%)", family, current.ID, current.Agreement*100, current.CandidateAccuracy*100, current.LiveAccuracy*100)
+
+	// compare the environment with the learning data of the candidate
+	globalDrift.Lock()
+	delete(globalDrift.Baselines, family)
+	globalDrift.Monitors[family] = models.NewDriftMonitor()
+	globalDrift.Unlock()
+
+	current.Status = "promoted"
+	promoted := time.Now().UTC()
+	current.Promoted = &promoted
+	report = copyShadowReport(current)
+	return
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/learning/hmm"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// DefaultSmoothingSettings are used for families that have not set their own
+var DefaultSmoothingSettings = models.SmoothingSettings{
+	Enabled:         false,
+	StayProbability: 0.9,
+	ResetSeconds:    300,
+}
+
+// smoothingHistory is how far back stored predictions are used to learn transitions
+const smoothingHistory = 30 * 24 * time.Hour
+
+// smoothingSaveInterval debounces saving the device beliefs to the database
+const smoothingSaveInterval = 10 * time.Second
+
+type familySmoothing struct {
+	// Settings are the smoothing settings, nil until loaded
+	Settings *models.SmoothingSettings
+	// Model is the transition model, nil until loaded
+	Model *hmm.Model
+	// Beliefs maps device -> belief, nil until loaded
+	Beliefs map[string]hmm.Belief
+	// LastSaved is the last time the beliefs were saved
+	LastSaved time.Time
+	sync.Mutex
+}
+
+type SmoothingMap struct {
+	// Families maps family -> its smoothing
+	Families map[string]*familySmoothing
+	sync.Mutex
+}
+
+var globalSmoothing SmoothingMap
+
+func init() {
+	globalSmoothing.Lock()
+	defer globalSmoothing.Unlock()
+	globalSmoothing.Families = make(map[string]*familySmoothing)
+}
+
+// getFamilySmoothing returns the smoothing of the family. Lock it before
+// using it.
+func getFamilySmoothing(family string) *familySmoothing {
+	globalSmoothing.Lock()
+	defer globalSmoothing.Unlock()
+	f, ok := globalSmoothing.Families[family]
+	if !ok {
+		f = new(familySmoothing)
+		globalSmoothing.Families[family] = f
+	}
+	return f
+}
+
+// GetSmoothingSettings returns the smoothing settings of the family, or the defaults
+func GetSmoothingSettings(db *database.Database) (settings models.SmoothingSettings) {
+	settings = DefaultSmoothingSettings
+	if err := db.Get("SmoothingSettings", &settings); err != nil {
+		settings = DefaultSmoothingSettings
+	}
+	if settings.StayProbability <= 0 || settings.StayProbability >= 1 {
+		settings.StayProbability = DefaultSmoothingSettings.StayProbability
+	}
+	if settings.ResetSeconds <= 0 {
+		settings.ResetSeconds = DefaultSmoothingSettings.ResetSeconds
+	}
+	return
+}
+
+// SetSmoothingSettings saves the smoothing settings and refits the transition model
+func SetSmoothingSettings(family string, settings models.SmoothingSettings, db *database.Database) (err error) {
+	if settings.StayProbability < 0 || settings.StayProbability >= 1 {
+		err = errors.New("stay probability must be between 0 and 1")
+		return
+	}
+	if err = db.Set("SmoothingSettings", settings); err != nil {
+		return
+	}
+	return FitSmoothing(family, db)
+}
+
+// FitSmoothing learns the transitions between locations from the stored
+// predictions of the tracking data.
+func FitSmoothing(family string, db *database.Database) (err error) {
+	settings := GetSmoothingSettings(db)
+	since := time.Now().Add(-smoothingHistory).UnixNano() / int64(time.Millisecond)
+	predictions, err := db.GetPredictionHistory(since)
+	if err != nil {
+		return
+	}
+
+	// split the predictions into a sequence of best guesses per device
+	sequences := [][]string{}
+	lastDevice := ""
+	var lastTimestamp int64
+	for _, p := range predictions {
+		if len(p.Guesses) == 0 || p.Guesses[0].Location == "?" {
+			continue
+		}
+		if p.Device != lastDevice || p.Timestamp-lastTimestamp > settings.ResetSeconds*1000 {
+			sequences = append(sequences, []string{})
+		}
+		sequences[len(sequences)-1] = append(sequences[len(sequences)-1], p.Guesses[0].Location)
+		lastDevice = p.Device
+		lastTimestamp = p.Timestamp
+	}
+
+	m := hmm.New(settings.StayProbability)
+	if len(sequences) > 0 {
+		if err = m.Fit(sequences); err != nil {
+			return
+		}
+	}
+	logger.Log.Debugf("[%s] fit smoothing on %d predictions from %d sequences", family, len(predictions), len(sequences))
+	if err = db.Set("SmoothingModel", m); err != nil {
+		return
+	}
+
+	f := getFamilySmoothing(family)
+	f.Lock()
+	f.Settings = &settings
+	f.Model = m
+	f.Unlock()
+	return
+}
+
+// SmoothLocation will run the forward filter of the device over the guesses of
+// the newest fingerprint, and set the smoothed guesses of the analysis. The
+// settings, model and beliefs of the family are kept in memory, and the
+// beliefs are saved every smoothingSaveInterval.
+func SmoothLocation(s models.SensorData, aidata *models.LocationAnalysis, db *database.Database) (err error) {
+	f := getFamilySmoothing(s.Family)
+	f.Lock()
+	loaded := f.Settings != nil && f.Model != nil && f.Beliefs != nil
+	f.Unlock()
+	if !loaded {
+		loadSmoothing(s.Family, f, db)
+	}
+
+	f.Lock()
+	settings := *f.Settings
+	if !settings.Enabled {
+		f.Unlock()
+		return
+	}
+	belief := f.Beliefs[s.Device]
+	if s.Timestamp-belief.Timestamp > settings.ResetSeconds*1000 {
+		belief = hmm.Belief{}
+	}
+	if !aidata.IsUnknown {
+		belief = f.Model.Update(belief, aidata.Guesses, s.Timestamp)
+		f.Beliefs[s.Device] = belief
+	}
+	aidata.SmoothedGuesses = belief.Guesses()
+
+	var beliefs map[string]hmm.Belief
+	if time.Since(f.LastSaved) > smoothingSaveInterval {
+		f.LastSaved = time.Now()
+		beliefs = make(map[string]hmm.Belief, len(f.Beliefs))
+		for device, b := range f.Beliefs {
+			beliefs[device] = b
+		}
+	}
+	f.Unlock()
+
+	if beliefs != nil {
+		err = db.Set("SmoothingBeliefs", beliefs)
+	}
+	return
+}
+
+// loadSmoothing reads the settings, model and beliefs of the family from the
+// database, and keeps those that were not set in the meantime
+func loadSmoothing(family string, f *familySmoothing, db *database.Database) {
+	settings := GetSmoothingSettings(db)
+	m := hmm.New(settings.StayProbability)
+	if errGet := db.Get("SmoothingModel", m); errGet != nil {
+		logger.Log.Debugf("[%s] no smoothing model, using only the stay probability", family)
+	}
+	m.StayProbability = settings.StayProbability
+	beliefs := make(map[string]hmm.Belief)
+	if errGet := db.Get("SmoothingBeliefs", &beliefs); errGet != nil || beliefs == nil {
+		beliefs = make(map[string]hmm.Belief)
+	}
+
+	f.Lock()
+	defer f.Unlock()
+	if f.Settings == nil {
+		f.Settings = &settings
+	}
+	if f.Model == nil {
+		f.Model = m
+	}
+	if f.Beliefs == nil {
+		f.Beliefs = beliefs
+	}
+}
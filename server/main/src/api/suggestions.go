@@ -0,0 +1,35 @@
+package api
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/schollz/find3/server/main/src/database"
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// suggestionHistory is how far back the tracking guesses are used for suggestions
+const suggestionHistory = 24 * time.Hour
+
+// GetSuggestions ranks the locations where more learning data should improve
+// the accuracy the most, from the last calibration and the recent tracking
+func GetSuggestions(db *database.Database) (suggestions []models.Suggestion, err error) {
+	var analysis map[string]models.ConfusionMatrix
+	if err = db.Get("PredictionAnalysis", &analysis); err != nil || analysis == nil {
+		err = errors.New("no prediction analysis, need to calibrate")
+		return
+	}
+	counts, err := db.GetLocationCounts()
+	if err != nil {
+		err = errors.Wrap(err, "could not get location counts")
+		return
+	}
+	since := time.Now().Add(-suggestionHistory).UnixNano() / int64(time.Millisecond)
+	tracking, err := db.GetPredictionHistory(since)
+	if err != nil {
+		err = errors.Wrap(err, "could not get tracking predictions")
+		return
+	}
+	suggestions = models.SuggestLearning(analysis, counts, tracking)
+	return
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -25,6 +27,12 @@ var mysqlUser = "root"
 var mysqlPW = "root"
 var dbNamePrefix = "find3_"
 
+// nonSensorColumns are the columns of the sensors table that are not a sensor type
+var nonSensorColumns = map[string]bool{"timestamp": true, "deviceid": true, "locationid": true, "status": true}
+
+// sensorTypeColumn matches the sensor types that can be a column of the sensors table
+var sensorTypeColumn = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
 // MakeTables creates two tables, a `keystore` table:
 //
 // 	KEY (TEXT)	VALUE (TEXT)
@@ -204,6 +212,38 @@ func (d *Database) Set(key string, value interface{}) (err error) {
 	return
 }
 
+// SetMany will set several values in one transaction, so that they all change together.
+func (d *Database) SetMany(keyValues map[string]interface{}) (err error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "SetMany")
+	}
+	sql := "insert into keystore(id,value) values(?,?) on duplicate key update value=?"
+	stmt, err := tx.Prepare(sql)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "SetMany")
+	}
+	defer stmt.Close()
+
+	for key, value := range keyValues {
+		var b []byte
+		if b, err = json.Marshal(value); err != nil {
+			tx.Rollback()
+			return
+		}
+		valueStr := string(b)
+		if _, err = stmt.Exec(key, valueStr, valueStr); err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "SetMany")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "SetMany")
+	}
+	return
+}
+
 // Dump will output the string version of the database
 func (d *Database) Dump() (dumped string, err error) {
 	var b bytes.Buffer
@@ -275,6 +315,45 @@ func (d *Database) GetPrediction(timestamp int64) (aidata []models.LocationPredi
 	return
 }
 
+// GetPredictionHistory will retrieve the predictions of the tracking data since a timestamp,
+// ordered by device and then by time
+func (d *Database) GetPredictionHistory(since int64) (predictions []models.DevicePrediction, err error) {
+	query := "SELECT sensors.deviceid, location_predictions.timestamp, location_predictions.prediction FROM location_predictions INNER JOIN sensors ON sensors.timestamp = location_predictions.timestamp WHERE sensors.locationid = '' AND location_predictions.timestamp > ? ORDER BY sensors.deviceid, location_predictions.timestamp"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query(since)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	predictions = []models.DevicePrediction{}
+	for rows.Next() {
+		var p models.DevicePrediction
+		var prediction string
+		err = rows.Scan(&p.Device, &p.Timestamp, &prediction)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		if err = json.Unmarshal([]byte(prediction), &p.Guesses); err != nil {
+			err = errors.Wrap(err, "unmarshaling prediction")
+			return
+		}
+		predictions = append(predictions, p)
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
 // StoreSensorData will insert a sensor data into the database
 func (d *Database) StoreSensorData(s models.SensorData) (err error) {
 
@@ -308,13 +387,35 @@ func (d *Database) StoreSensorData(s models.SensorData) (err error) {
 	}
 	previousCurrent := sensorDataSS.Current
 
-	args := make([]interface{}, 4)
-	args[0] = s.Timestamp
-	args[1] = s.Device
-	args[2] = s.Location
-	args[3] = sensorDataSS.ShrinkMapToString(s.Sensors["bluetooth"])
+	// each sensor type is a column, which is added the first time the type is stored
+	sensorTypes := make([]string, 0, len(s.Sensors))
+	for sensorType := range s.Sensors {
+		if !sensorTypeColumn.MatchString(sensorType) || nonSensorColumns[sensorType] {
+			err = errors.Errorf("sensor type '%s' cannot be stored", sensorType)
+			return
+		}
+		sensorTypes = append(sensorTypes, sensorType)
+	}
+	sort.Strings(sensorTypes)
+	for _, sensorType := range sensorTypes {
+		if _, ok := oldColumns[sensorType]; ok {
+			continue
+		}
+		if err = d.addSensorColumn(sensorType); err != nil {
+			return
+		}
+	}
+
+	args := []interface{}{s.Timestamp, s.Device, s.Location}
+	columns := "timestamp,deviceid,locationid"
+	values := "?,?,?"
+	for _, sensorType := range sensorTypes {
+		args = append(args, sensorDataSS.ShrinkMapToString(s.Sensors[sensorType]))
+		columns += ",`" + sensorType + "`"
+		values += ",?"
+	}
 
-	sqlStatement := "insert into sensors(timestamp,deviceid,locationid,bluetooth) values (?,?,?,?)"
+	sqlStatement := "insert into sensors(" + columns + ") values (" + values + ")"
 	stmt, err := d.db.Prepare(sqlStatement)
 	if err != nil {
 		return errors.Wrap(err, "StoreSensorData, prepare "+sqlStatement)
@@ -337,6 +438,27 @@ func (d *Database) StoreSensorData(s models.SensorData) (err error) {
 
 }
 
+// addSensorColumn adds the column of a new sensor type to the sensors table
+func (d *Database) addSensorColumn(sensorType string) (err error) {
+	if _, err = d.db.Exec("ALTER TABLE sensors ADD COLUMN `" + sensorType + "` TEXT"); err == nil {
+		logger.Log.Infof("[%s] added sensor type %s", d.family, sensorType)
+		return
+	}
+	// the column may have been added by another insert in the meantime
+	columns, errColumns := d.Columns()
+	if errColumns != nil {
+		return
+	}
+	for _, column := range columns {
+		if column == sensorType {
+			err = nil
+			return
+		}
+	}
+	err = errors.Wrap(err, "addSensorColumn")
+	return
+}
+
 // GetSensorFromTime will return a sensor data for a given timestamp
 func (d *Database) GetSensorFromTime(timestamp interface{}) (s models.SensorData, err error) {
 	sensors, err := d.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE timestamp = ?", timestamp)
@@ -562,9 +684,43 @@ func (d *Database) GetLocationCounts() (counts map[string]int, err error) {
 	return
 }
 
+// GetLocationGPS returns the mean GPS position of each location in the gps table
+func (d *Database) GetLocationGPS() (gps map[string]models.GPS, err error) {
+	gps = make(map[string]models.GPS)
+	query := "SELECT loc, avg(lat), avg(lon), avg(alt) FROM gps WHERE loc != '' GROUP BY loc"
+	stmt, err := d.db.Prepare(query)
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer stmt.Close()
+	rows, err := stmt.Query()
+	if err != nil {
+		err = errors.Wrap(err, query)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var g models.GPS
+		err = rows.Scan(&name, &g.Latitude, &g.Longitude, &g.Altitude)
+		if err != nil {
+			err = errors.Wrap(err, "scanning")
+			return
+		}
+		gps[name] = g
+	}
+	err = rows.Err()
+	if err != nil {
+		err = errors.Wrap(err, "rows")
+	}
+	return
+}
+
 // GetAllForClassification will return a sensor data for classifying
 func (d *Database) GetAllForClassification() (s []models.SensorData, err error) {
-	return d.GetAllFromQuery("SELECT timestamp, deviceid, locationid, bluetooth FROM sensors WHERE sensors.locationid !='' AND status = 'active' ORDER BY timestamp")
+	return d.GetAllFromQuery("SELECT * FROM sensors WHERE sensors.locationid !='' AND status = 'active' ORDER BY timestamp")
 }
 
 // GetAllNotForClassification will return a sensor data for classifying
@@ -572,6 +728,11 @@ func (d *Database) GetAllNotForClassification() (s []models.SensorData, err erro
 	return d.GetAllFromQuery("SELECT * FROM sensors WHERE sensors.locationid =='' ORDER BY timestamp")
 }
 
+// GetTrackingSince will return the tracking sensor data since a timestamp
+func (d *Database) GetTrackingSince(timestamp int64) (s []models.SensorData, err error) {
+	return d.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE sensors.locationid = '' AND timestamp > ? ORDER BY timestamp", timestamp)
+}
+
 // GetLatest will return a sensor data for classifying
 func (d *Database) GetLatest(device string) (s models.SensorData, err error) {
 	var sensors []models.SensorData
@@ -587,6 +748,21 @@ func (d *Database) GetLatest(device string) (s models.SensorData, err error) {
 	return
 }
 
+// GetLatestBefore will return the latest sensor data of a device before a timestamp
+func (d *Database) GetLatestBefore(device string, timestamp int64) (s models.SensorData, err error) {
+	var sensors []models.SensorData
+	sensors, err = d.GetAllFromPreparedQuery("SELECT * FROM sensors WHERE deviceid=? AND timestamp < ? ORDER BY timestamp DESC LIMIT 1", strings.TrimSpace(device), timestamp)
+	if err != nil {
+		return
+	}
+	if len(sensors) > 0 {
+		s = sensors[0]
+	} else {
+		err = errors.New("no rows found")
+	}
+	return
+}
+
 func (d *Database) GetKeys(keylike string) (keys []string, err error) {
 	query := "SELECT key FROM keystore WHERE key LIKE ?"
 	stmt, err := d.db.Prepare(query)
@@ -798,6 +974,134 @@ func Open(family string, readOnly ...bool) (d *Database, err error) {
 	return
 }
 
+// Families lists the families that have a database
+func Families() (families []string, err error) {
+	server, err := sql.Open("mysql", fmt.Sprintf("%s:%s@/", mysqlUser, mysqlPW))
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	rows, err := server.Query("SELECT schema_name FROM information_schema.schemata WHERE schema_name LIKE ?", strings.Replace(dbNamePrefix, "_", "\\_", -1)+"%")
+	if err != nil {
+		err = errors.Wrap(err, "Families")
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			err = errors.Wrap(err, "Families")
+			return
+		}
+		families = append(families, strings.TrimPrefix(name, dbNamePrefix))
+	}
+	err = rows.Err()
+	return
+}
+
+// Exists returns whether the family has a database
+func Exists(family string) (exists bool, err error) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	server, err := sql.Open("mysql", fmt.Sprintf("%s:%s@/", mysqlUser, mysqlPW))
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	var name string
+	err = server.QueryRow("SELECT schema_name FROM information_schema.schemata WHERE schema_name = ?", dbNamePrefix+family).Scan(&name)
+	if err == sql.ErrNoRows {
+		err = nil
+		return
+	} else if err != nil {
+		err = errors.Wrap(err, "Exists")
+		return
+	}
+	exists = true
+	return
+}
+
+// Drop will delete the scratch database of the family made by Create
+func Drop(family string) (err error) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if family == "default" {
+		return errors.New("cannot drop the default database")
+	}
+	server, err := sql.Open("mysql", fmt.Sprintf("%s:%s@/", mysqlUser, mysqlPW))
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	if _, err = server.Exec("DROP DATABASE IF EXISTS " + dbNamePrefix + family); err != nil {
+		err = errors.Wrap(err, "Drop")
+		return
+	}
+	logger.Log.Infof("dropped database %s", dbNamePrefix+family)
+	return
+}
+
+// Create will create a database for the family, if it does not exist, with
+// the tables of the "default" database, which the server opens at start. It
+// is for the scratch databases of candidate models and replays; the sensor
+// data is kept in the "default" database.
+func Create(family string) (err error) {
+	family = strings.ToLower(strings.TrimSpace(family))
+	if family == "default" {
+		return
+	}
+	name := dbNamePrefix + family
+
+	server, err := sql.Open("mysql", fmt.Sprintf("%s:%s@/", mysqlUser, mysqlPW))
+	if err != nil {
+		return
+	}
+	defer server.Close()
+	if _, err = server.Exec("CREATE DATABASE IF NOT EXISTS " + name); err != nil {
+		err = errors.Wrap(err, "Create")
+		return
+	}
+	rows, err := server.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = ?", dbNamePrefix+"default")
+	if err != nil {
+		err = errors.Wrap(err, "Create")
+		return
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			rows.Close()
+			return
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if len(tables) == 0 {
+		err = errors.New("the default database has no tables to copy")
+		return
+	}
+	for _, table := range tables {
+		if _, err = server.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s LIKE %sdefault.%s", name, table, dbNamePrefix, table)); err != nil {
+			err = errors.Wrap(err, "Create")
+			return
+		}
+	}
+
+	// a new database needs its own sizer of the sensor names
+	d, err := Open(family)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	var sensorDataStringSizerString string
+	if errGet := d.Get("sensorDataStringSizer", &sensorDataStringSizerString); errGet != nil {
+		sensorDataSS, _ := stringsizer.New()
+		if err = d.Set("sensorDataStringSizer", sensorDataSS.Save()); err != nil {
+			return
+		}
+		logger.Log.Infof("created database %s", name)
+	}
+	return
+}
+
 func (d *Database) Debug(debugMode bool) {
 	if debugMode {
 		logger.SetLevel("debug")
@@ -882,16 +1186,39 @@ func (d *Database) getRows(rows *sql.Rows) (sensorData []models.SensorData, err
 		return
 	}
 
+	// the columns that are not known are the sensor types
+	columns, err := rows.Columns()
+	if err != nil {
+		err = errors.Wrap(err, "getRows")
+		return
+	}
+
 	// loop through rows of sql result
 	var (
 		timestamp  int64
 		deviceid   string
 		locationid string
-		bluetooth  string
+		status     sql.NullString
 	)
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i, column := range columns {
+		switch column {
+		case "timestamp":
+			pointers[i] = &timestamp
+		case "deviceid":
+			pointers[i] = &deviceid
+		case "locationid":
+			pointers[i] = &locationid
+		case "status":
+			pointers[i] = &status
+		default:
+			pointers[i] = &values[i]
+		}
+	}
 	sensorData = []models.SensorData{}
 	for rows.Next() {
-		if err = rows.Scan(&timestamp, &deviceid, &locationid, &bluetooth); err != nil {
+		if err = rows.Scan(pointers...); err != nil {
 			err = errors.Wrap(err, "getRows")
 			return
 		}
@@ -903,8 +1230,13 @@ func (d *Database) getRows(rows *sql.Rows) (sensorData []models.SensorData, err
 			Location:  locationid,
 			Sensors:   make(map[string]map[string]interface{}),
 		}
-		if s.Sensors["bluetooth"], err = sensorDataSS.ExpandMapFromString(bluetooth); err != nil {
-			return
+		for i, column := range columns {
+			if nonSensorColumns[column] || !values[i].Valid || values[i].String == "" {
+				continue
+			}
+			if s.Sensors[column], err = sensorDataSS.ExpandMapFromString(values[i].String); err != nil {
+				return
+			}
 		}
 		sensorData = append(sensorData, s)
 	}
@@ -0,0 +1,145 @@
+package hmm
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// minimumEmission keeps a location reachable when a classifier gives it no probability
+const minimumEmission = 0.01
+
+// Model is a hidden Markov model where the hidden state is the true location
+// of a device and the observations are the guesses of the classifiers.
+type Model struct {
+	// Transitions maps location -> location -> number of observed moves
+	Transitions map[string]map[string]float64 `json:"transitions"`
+	// StayProbability is the probability that a device stays in the
+	// same location between two consecutive fingerprints
+	StayProbability float64 `json:"stay_probability"`
+}
+
+// Belief is the filtered state of a single device
+type Belief struct {
+	// Probabilities maps location -> probability
+	Probabilities map[string]float64 `json:"probabilities"`
+	// Timestamp is the time of the last update, in milliseconds
+	Timestamp int64 `json:"time"`
+}
+
+// New returns a new model
+func New(stayProbability float64) *Model {
+	m := new(Model)
+	m.Transitions = make(map[string]map[string]float64)
+	m.StayProbability = stayProbability
+	return m
+}
+
+// Fit will count the moves between consecutive locations in each sequence
+func (m *Model) Fit(sequences [][]string) (err error) {
+	if len(sequences) == 0 {
+		err = errors.New("no data")
+		return
+	}
+	m.Transitions = make(map[string]map[string]float64)
+	for _, sequence := range sequences {
+		for i := 1; i < len(sequence); i++ {
+			from, to := sequence[i-1], sequence[i]
+			if _, ok := m.Transitions[from]; !ok {
+				m.Transitions[from] = make(map[string]float64)
+			}
+			if from == to {
+				continue
+			}
+			m.Transitions[from][to]++
+		}
+	}
+	return
+}
+
+// Transition returns the probability of moving from one location to another,
+// given the set of possible locations. Moves that were never observed keep a
+// small probability so that the filter can always recover.
+func (m *Model) Transition(from, to string, locations []string) float64 {
+	if from == to {
+		return m.StayProbability
+	}
+	if len(locations) < 2 {
+		return 0
+	}
+	total := float64(0)
+	for _, loc := range locations {
+		if loc != from {
+			total += m.Transitions[from][loc] + 1
+		}
+	}
+	return (1 - m.StayProbability) * (m.Transitions[from][to] + 1) / total
+}
+
+// Update runs one step of the forward filter, combining the previous belief
+// with the guesses for the newest fingerprint.
+func (m *Model) Update(b Belief, guesses []models.LocationPrediction, timestamp int64) (updated Belief) {
+	if len(guesses) == 0 {
+		return b
+	}
+
+	// the state space is every location known to the belief or the guesses
+	emissions := make(map[string]float64)
+	for loc := range b.Probabilities {
+		emissions[loc] = minimumEmission
+	}
+	for _, guess := range guesses {
+		if guess.Probability > minimumEmission {
+			emissions[guess.Location] = guess.Probability
+		} else {
+			emissions[guess.Location] = minimumEmission
+		}
+	}
+	locations := make([]string, 0, len(emissions))
+	for loc := range emissions {
+		locations = append(locations, loc)
+	}
+	sort.Strings(locations)
+
+	updated.Probabilities = make(map[string]float64)
+	updated.Timestamp = timestamp
+	total := float64(0)
+	for _, to := range locations {
+		predicted := float64(0)
+		if len(b.Probabilities) == 0 {
+			predicted = 1 / float64(len(locations))
+		} else {
+			for from, p := range b.Probabilities {
+				predicted += p * m.Transition(from, to, locations)
+			}
+		}
+		updated.Probabilities[to] = predicted * emissions[to]
+		total += updated.Probabilities[to]
+	}
+	if total == 0 {
+		return b
+	}
+	for loc := range updated.Probabilities {
+		updated.Probabilities[loc] = updated.Probabilities[loc] / total
+	}
+	return
+}
+
+// Guesses returns the belief as location predictions, best first
+func (b Belief) Guesses() (guesses []models.LocationPrediction) {
+	guesses = make([]models.LocationPrediction, 0, len(b.Probabilities))
+	for loc, p := range b.Probabilities {
+		guesses = append(guesses, models.LocationPrediction{
+			Location:    loc,
+			Probability: float64(int(p*100000)) / 100000,
+		})
+	}
+	sort.Slice(guesses, func(i, j int) bool {
+		if guesses[i].Probability == guesses[j].Probability {
+			return guesses[i].Location < guesses[j].Location
+		}
+		return guesses[i].Probability > guesses[j].Probability
+	})
+	return
+}
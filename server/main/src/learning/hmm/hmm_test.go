@@ -0,0 +1,75 @@
+package hmm
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// noisyGuesses simulates a classifier that is right most of the time but
+// sometimes prefers a neighbouring room
+func noisyGuesses(r *rand.Rand, truth string, locations []string) []models.LocationPrediction {
+	guessed := truth
+	if r.Float64() < 0.35 {
+		guessed = locations[r.Intn(len(locations))]
+	}
+	guesses := []models.LocationPrediction{{Location: guessed, Probability: 0.55}}
+	for _, loc := range locations {
+		if loc != guessed {
+			p := 0.45 / float64(len(locations)-1)
+			if loc == truth {
+				p = 0.3
+			}
+			guesses = append(guesses, models.LocationPrediction{Location: loc, Probability: p})
+		}
+	}
+	return guesses
+}
+
+func TestReplay(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	locations := []string{"kitchen", "living room", "bedroom", "bathroom"}
+
+	// a device that dwells in each room before walking to the next
+	truth := []string{}
+	for i := 0; i < 40; i++ {
+		loc := locations[r.Intn(len(locations))]
+		for j := 0; j < 5+r.Intn(10); j++ {
+			truth = append(truth, loc)
+		}
+	}
+
+	m := New(0.9)
+	assert.Nil(t, m.Fit([][]string{truth}))
+	assert.NotNil(t, New(0.9).Fit([][]string{}))
+
+	var b Belief
+	rawCorrect, smoothedCorrect := 0, 0
+	for i, loc := range truth {
+		guesses := noisyGuesses(r, loc, locations)
+		if guesses[0].Location == loc {
+			rawCorrect++
+		}
+		b = m.Update(b, guesses, int64(i))
+		if b.Guesses()[0].Location == loc {
+			smoothedCorrect++
+		}
+	}
+	t.Logf("raw: %d/%d, smoothed: %d/%d", rawCorrect, len(truth), smoothedCorrect, len(truth))
+	assert.True(t, smoothedCorrect > rawCorrect)
+}
+
+func TestTransition(t *testing.T) {
+	m := New(0.8)
+	assert.Nil(t, m.Fit([][]string{{"a", "b", "b", "a", "b", "c"}}))
+	locations := []string{"a", "b", "c"}
+	assert.Equal(t, 0.8, m.Transition("a", "a", locations))
+	total := float64(0)
+	for _, to := range locations {
+		total += m.Transition("a", to, locations)
+	}
+	assert.InDelta(t, 1, total, 1e-9)
+	assert.True(t, m.Transition("a", "b", locations) > m.Transition("a", "c", locations))
+}
@@ -0,0 +1,75 @@
+package nb1
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// ExpectedDetectionRate is how often a sensor must be seen at a location to
+// be missed when a fingerprint does not have it
+const ExpectedDetectionRate = 0.5
+
+// Explain returns what each sensor of the data adds to the log probability
+// of the location, and the sensors usually seen there that are missing
+func (a *Algorithm) Explain(data models.SensorData, location string) (explanation models.LocationExplanation, err error) {
+	if err = a.load(data.Family); err != nil {
+		return
+	}
+	if _, ok := a.Data[location]; !ok {
+		err = errors.New("location '" + location + "' was not learned")
+		return
+	}
+
+	explanation.Location = location
+	explanation.Contributions = []models.SensorContribution{}
+	seen := make(map[string]struct{})
+	for sensorType := range data.Sensors {
+		for name, value := range data.Sensors[sensorType] {
+			v, ok := value.(float64)
+			if !ok {
+				continue
+			}
+			mac := sensorType + "-" + name
+			seen[mac] = struct{}{}
+			explanation.Contributions = append(explanation.Contributions, models.SensorContribution{
+				Sensor:         mac,
+				Value:          v,
+				LogProbability: a.logProbability(mac, int(v), location),
+			})
+		}
+	}
+	sort.Slice(explanation.Contributions, func(i, j int) bool {
+		ci, cj := explanation.Contributions[i], explanation.Contributions[j]
+		if ci.LogProbability == cj.LogProbability {
+			return ci.Sensor < cj.Sensor
+		}
+		return ci.LogProbability > cj.LogProbability
+	})
+
+	if a.Counts[location] == 0 {
+		return
+	}
+	for mac, values := range a.Data[location] {
+		if _, ok := seen[mac]; ok {
+			continue
+		}
+		count := 0
+		for _, n := range values {
+			count += n
+		}
+		rate := float64(count) / float64(a.Counts[location])
+		if rate >= ExpectedDetectionRate {
+			explanation.Missing = append(explanation.Missing, models.MissingSensor{Sensor: mac, DetectionRate: rate})
+		}
+	}
+	sort.Slice(explanation.Missing, func(i, j int) bool {
+		mi, mj := explanation.Missing[i], explanation.Missing[j]
+		if mi.DetectionRate == mj.DetectionRate {
+			return mi.Sensor < mj.Sensor
+		}
+		return mi.DetectionRate > mj.DetectionRate
+	})
+	return
+}
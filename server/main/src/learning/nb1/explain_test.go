@@ -0,0 +1,44 @@
+package nb1
+
+import (
+	"testing"
+
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	a := New()
+	a.Data = map[string]map[string]map[int]int{
+		"kitchen": {
+			"wifi-a": {-40: 4},
+			"wifi-b": {-80: 4},
+			"wifi-c": {-70: 1},
+		},
+		"living": {
+			"wifi-a": {-80: 4},
+			"wifi-b": {-40: 4},
+		},
+	}
+	a.Counts = map[string]int{"kitchen": 4, "living": 4}
+	a.isLoaded = true
+
+	s := models.SensorData{
+		Family:  "family",
+		Sensors: map[string]map[string]interface{}{"wifi": {"a": -40.0}},
+	}
+	e, err := a.Explain(s, "kitchen")
+	assert.Nil(t, err)
+	assert.Equal(t, "kitchen", e.Location)
+	assert.Equal(t, 1, len(e.Contributions))
+	assert.Equal(t, "wifi-a", e.Contributions[0].Sensor)
+	// the sensor supports the kitchen more than the living room
+	other, err := a.Explain(s, "living")
+	assert.Nil(t, err)
+	assert.True(t, e.Contributions[0].LogProbability > other.Contributions[0].LogProbability)
+	// wifi-b is always seen in the kitchen, wifi-c only a quarter of the time
+	assert.Equal(t, []models.MissingSensor{{Sensor: "wifi-b", DetectionRate: 1}}, e.Missing)
+
+	_, err = a.Explain(s, "garage")
+	assert.NotNil(t, err)
+}
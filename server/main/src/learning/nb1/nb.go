@@ -11,7 +11,9 @@ import (
 
 // Algorithm defines the basic structure
 type Algorithm struct {
-	Data     map[string]map[string]map[int]int
+	Data map[string]map[string]map[int]int
+	// Counts maps location -> number of fingerprints learned
+	Counts   map[string]int
 	isLoaded bool
 }
 
@@ -19,21 +21,43 @@ type Algorithm struct {
 func New() *Algorithm {
 	n := new(Algorithm)
 	n.Data = make(map[string]map[string]map[int]int)
+	n.Counts = make(map[string]int)
 	n.isLoaded = false
 	return n
 }
 
-// Fit will take the data and learn it
+// Fit will take the data, learn it and save it in the database of the family
 func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
+	if err = a.Learn(datas); err != nil {
+		return
+	}
+	db, err := database.Open(datas[0].Family)
+	if err != nil {
+		return
+	}
+	defer db.Close()
+	if err = db.Set("NB1", a.Data); err != nil {
+		return
+	}
+	err = db.Set("NB1Counts", a.Counts)
+	return
+}
+
+// Learn will take the data and learn it, without saving it. The data and
+// counts are saved as "NB1" and "NB1Counts" for classifying.
+func (a *Algorithm) Learn(datas []models.SensorData) (err error) {
 	if len(datas) == 0 {
 		err = errors.New("no data")
 		return
 	}
 	a.Data = make(map[string]map[string]map[int]int)
+	a.Counts = make(map[string]int)
 	for _, data := range datas {
+		data = data.Encoded()
 		if _, ok := a.Data[data.Location]; !ok {
 			a.Data[data.Location] = make(map[string]map[int]int)
 		}
+		a.Counts[data.Location]++
 		for sensorType := range data.Sensors {
 			for sensor := range data.Sensors[sensorType] {
 				mac := sensorType + "-" + sensor
@@ -48,51 +72,51 @@ func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
 			}
 		}
 	}
-	db, err := database.Open(datas[0].Family)
+	a.isLoaded = true
+	return
+}
+
+// load gets the fitted data of the family, if not already loaded
+func (a *Algorithm) load(family string) (err error) {
+	if a.isLoaded {
+		return
+	}
+	db, err := database.Open(family, true)
 	if err != nil {
 		return
 	}
 	defer db.Close()
-	err = db.Set("NB1", a.Data)
+	if err = db.Get("NB1", &a.Data); err != nil {
+		return
+	}
+	// the counts were not saved by older calibrations
+	db.Get("NB1Counts", &a.Counts)
+	a.isLoaded = true
 	return
 }
 
 // Classify will classify the specified data
 func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 	// load data if not already
-	if !a.isLoaded {
-		db, err2 := database.Open(data.Family, true)
-		if err2 != nil {
-			err = err2
-			return
-		}
-		defer db.Close()
-		if err = db.Get("NB1", &a.Data); err != nil {
-			return
-		}
-		a.isLoaded = true
+	if err = a.load(data.Family); err != nil {
+		return
 	}
 	if len(a.Data) == 0 {
 		err = errors.New("need to fit first")
 		return
 	}
 
-	numLocations := float64(len(a.Data))
-	NA := 1 / numLocations
-	NnotA := 1 - NA
 	Ps := make(map[string][]float64)
 	for location := range a.Data {
 		Ps[location] = []float64{}
 	}
+	data = data.Encoded()
 	for sensorType := range data.Sensors {
 		for name := range data.Sensors[sensorType] {
 			mac := sensorType + "-" + name
 			val := int(data.Sensors[sensorType][name].(float64))
 			for location := range Ps {
-				PA := a.probMacGivenLocation(mac, val, location, true)
-				PnotA := a.probMacGivenLocation(mac, val, location, false)
-				P := PA * NA / (PA*NA + PnotA*NnotA)
-				Ps[location] = append(Ps[location], math.Log(P))
+				Ps[location] = append(Ps[location], a.logProbability(mac, val, location))
 			}
 		}
 	}
@@ -120,6 +144,16 @@ func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 	return
 }
 
+// logProbability returns the log of the probability of the location given
+// the value of a sensor, which is what the sensor adds to the location
+func (a *Algorithm) logProbability(mac string, val int, location string) float64 {
+	NA := 1 / float64(len(a.Data))
+	NnotA := 1 - NA
+	PA := a.probMacGivenLocation(mac, val, location, true)
+	PnotA := a.probMacGivenLocation(mac, val, location, false)
+	return math.Log(PA * NA / (PA*NA + PnotA*NnotA))
+}
+
 type Pair struct {
 	Key   string
 	Value float64
@@ -32,6 +32,7 @@ func (a *Algorithm) Fit(datas []models.SensorData) (err error) {
 	a.Data = make(map[string]map[string]float64)
 	locationTotals := make(map[string]float64)
 	for _, data := range datas {
+		data = data.Encoded()
 		if _, ok := a.Data[data.Location]; !ok {
 			a.Data[data.Location] = make(map[string]float64)
 			locationTotals[data.Location] = float64(0)
@@ -90,6 +91,7 @@ func (a *Algorithm) Classify(data models.SensorData) (pl PairList, err error) {
 	for location := range a.Data {
 		Ps[location] = []float64{}
 	}
+	data = data.Encoded()
 	for sensorType := range data.Sensors {
 		for name := range data.Sensors[sensorType] {
 			mac := sensorType + "-" + name
@@ -0,0 +1,116 @@
+package stacked
+
+import (
+	"errors"
+	"math"
+
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+const (
+	learningRate = 0.5
+	iterations   = 500
+)
+
+// Model is a logistic regression that is stacked on top of the algorithms,
+// scoring each location by the probabilities that the algorithms gave it.
+type Model struct {
+	// Weights maps algorithm -> weight of its probability
+	Weights map[string]float64 `json:"weights"`
+	Bias    float64            `json:"bias"`
+}
+
+// New returns a new model
+func New() *Model {
+	m := new(Model)
+	m.Weights = make(map[string]float64)
+	return m
+}
+
+// features returns, for each location, the probability given to it by each algorithm
+func features(aidata models.LocationAnalysis) (locationFeatures map[string]map[string]float64) {
+	locationFeatures = make(map[string]map[string]float64)
+	for _, prediction := range aidata.Predictions {
+		for i := range prediction.Locations {
+			if i >= len(prediction.Probabilities) {
+				break
+			}
+			location := aidata.LocationNames[prediction.Locations[i]]
+			if location == "" {
+				continue
+			}
+			if _, ok := locationFeatures[location]; !ok {
+				locationFeatures[location] = make(map[string]float64)
+			}
+			locationFeatures[location][prediction.Name] = prediction.Probabilities[i]
+		}
+	}
+	return
+}
+
+// Fit will learn the weights from analyses of fingerprints with known locations
+func (m *Model) Fit(aidatas []models.LocationAnalysis, trueLocations []string) (err error) {
+	if len(aidatas) == 0 || len(aidatas) != len(trueLocations) {
+		err = errors.New("need an equal number of analyses and locations")
+		return
+	}
+
+	// every (fingerprint, location) pair is a sample, positive for the true location
+	type sample struct {
+		x map[string]float64
+		y float64
+	}
+	samples := []sample{}
+	for i, aidata := range aidatas {
+		for location, x := range features(aidata) {
+			y := float64(0)
+			if location == trueLocations[i] {
+				y = 1
+			}
+			samples = append(samples, sample{x: x, y: y})
+			for algorithm := range x {
+				m.Weights[algorithm] = 0
+			}
+		}
+	}
+	if len(samples) == 0 {
+		err = errors.New("no predictions to fit")
+		return
+	}
+
+	// batch gradient descent on the log loss
+	n := float64(len(samples))
+	for iteration := 0; iteration < iterations; iteration++ {
+		gradients := make(map[string]float64)
+		gradientBias := float64(0)
+		for _, s := range samples {
+			residual := m.predict(s.x) - s.y
+			for algorithm, v := range s.x {
+				gradients[algorithm] += residual * v
+			}
+			gradientBias += residual
+		}
+		for algorithm := range m.Weights {
+			m.Weights[algorithm] -= learningRate * gradients[algorithm] / n
+		}
+		m.Bias -= learningRate * gradientBias / n
+	}
+	return
+}
+
+func (m *Model) predict(x map[string]float64) float64 {
+	z := m.Bias
+	for algorithm, v := range x {
+		z += m.Weights[algorithm] * v
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+// Score returns the probability of each location
+func (m *Model) Score(aidata models.LocationAnalysis) (scores map[string]float64) {
+	scores = make(map[string]float64)
+	for location, x := range features(aidata) {
+		scores[location] = m.predict(x)
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package stacked
+
+import (
+	"testing"
+
+	"github.com/schollz/find3/server/main/src/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func analysis(good, bad string) models.LocationAnalysis {
+	return models.LocationAnalysis{
+		LocationNames: map[string]string{"0": "kitchen", "1": "bedroom"},
+		Predictions: []models.AlgorithmPrediction{
+			{Name: "good", Locations: []string{good, bad}, Probabilities: []float64{0.9, 0.1}},
+			{Name: "bad", Locations: []string{bad, good}, Probabilities: []float64{0.8, 0.2}},
+		},
+	}
+}
+
+func TestStacked(t *testing.T) {
+	m := New()
+	assert.NotNil(t, m.Fit([]models.LocationAnalysis{}, []string{}))
+
+	// the "good" algorithm is always right, the "bad" one always wrong
+	aidatas := []models.LocationAnalysis{}
+	truths := []string{}
+	for i := 0; i < 10; i++ {
+		aidatas = append(aidatas, analysis("0", "1"))
+		truths = append(truths, "kitchen")
+		aidatas = append(aidatas, analysis("1", "0"))
+		truths = append(truths, "bedroom")
+	}
+	assert.Nil(t, m.Fit(aidatas, truths))
+	assert.True(t, m.Weights["good"] > m.Weights["bad"])
+
+	scores := m.Score(analysis("1", "0"))
+	assert.True(t, scores["bedroom"] > scores["kitchen"])
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"math"
+	"strings"
+
+	"github.com/schollz/find3/server/main/src/utils"
+)
+
+// RSSIStats are the running mean and standard deviation of a signal strength
+type RSSIStats struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	SD    float64 `json:"sd"`
+	// M2 is the sum of squared differences from the mean
+	M2 float64 `json:"m2"`
+}
+
+// Add adds a signal strength, updating the mean and standard deviation
+func (r *RSSIStats) Add(rssi float64) {
+	r.Count++
+	delta := rssi - r.Mean
+	r.Mean += delta / float64(r.Count)
+	r.M2 += delta * (rssi - r.Mean)
+	r.SD = math.Sqrt(r.M2 / float64(r.Count))
+}
+
+// BeaconStats describe a beacon or access point heard by a family
+type BeaconStats struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	// FirstSeen and LastSeen are timestamps in milliseconds
+	FirstSeen  int64  `json:"first_seen"`
+	LastSeen   int64  `json:"last_seen"`
+	Detections int    `json:"detections"`
+	Vendor     string `json:"vendor,omitempty"`
+	Randomized bool   `json:"randomized"`
+	// NumLocations is the number of learned locations the beacon was heard in
+	NumLocations int `json:"num_locations"`
+	// Locations maps location -> signal strength in its learning data
+	Locations map[string]*RSSIStats `json:"locations"`
+}
+
+// BeaconInventory maps "type-id" -> the beacons heard by a family
+type BeaconInventory map[string]*BeaconStats
+
+// Add adds the sensors of a fingerprint to the inventory, and returns
+// whether any beacon is new
+func (inv BeaconInventory) Add(s SensorData) (added bool) {
+	for sensorType := range s.Sensors {
+		for id, value := range s.Sensors[sensorType] {
+			key := sensorType + "-" + id
+			b, ok := inv[key]
+			if !ok {
+				b = &BeaconStats{
+					Type:      sensorType,
+					ID:        id,
+					FirstSeen: s.Timestamp,
+					Locations: make(map[string]*RSSIStats),
+				}
+				if RSSISensorTypes[sensorType] {
+					if vendor, err := utils.GetVendorFromOUI(id); err == nil {
+						b.Vendor = vendor
+					}
+					b.Randomized = utils.IsMacRandomized(id)
+				}
+				inv[key] = b
+				added = true
+			}
+			b.Detections++
+			if s.Timestamp < b.FirstSeen {
+				b.FirstSeen = s.Timestamp
+			}
+			if s.Timestamp > b.LastSeen {
+				b.LastSeen = s.Timestamp
+			}
+			v, err := ParseSensorValue(sensorType, value)
+			if s.Location == "" || err != nil || v.Kind != RSSIValue {
+				continue
+			}
+			if _, ok := b.Locations[s.Location]; !ok {
+				b.Locations[s.Location] = new(RSSIStats)
+				b.NumLocations = len(b.Locations)
+			}
+			b.Locations[s.Location].Add(v.Number)
+		}
+	}
+	return
+}
+
+// Prune removes the beacons with randomized addresses that were last seen
+// before the timestamp, in milliseconds, as they are not heard again once
+// their address changes. It returns the number of beacons removed.
+func (inv BeaconInventory) Prune(before int64) (removed int) {
+	for key, b := range inv {
+		if b.Randomized && b.LastSeen < before {
+			delete(inv, key)
+			removed++
+		}
+	}
+	return
+}
+
+// Filter returns the beacons of a sensor type, or all when it is empty
+func (inv BeaconInventory) Filter(sensorType string) (filtered BeaconInventory) {
+	filtered = make(BeaconInventory)
+	for key, b := range inv {
+		if sensorType == "" || strings.EqualFold(b.Type, sensorType) {
+			filtered[key] = b
+		}
+	}
+	return
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSSIStats(t *testing.T) {
+	var r RSSIStats
+	for _, rssi := range []float64{-50, -60, -70} {
+		r.Add(rssi)
+	}
+	assert.Equal(t, 3, r.Count)
+	assert.InDelta(t, -60, r.Mean, 1e-9)
+	assert.InDelta(t, math.Sqrt(200.0/3), r.SD, 1e-9)
+}
+
+func TestBeaconInventory(t *testing.T) {
+	inv := make(BeaconInventory)
+	added := inv.Add(SensorData{
+		Timestamp: 2000,
+		Location:  "kitchen",
+		Sensors: map[string]map[string]interface{}{
+			"wifi":      {"00:11:22:33:44:55": -50.0},
+			"bluetooth": {"da:a1:19:00:00:01": -70.0},
+		},
+	})
+	assert.True(t, added)
+	added = inv.Add(SensorData{
+		Timestamp: 1000,
+		Location:  "living",
+		Sensors:   map[string]map[string]interface{}{"wifi": {"00:11:22:33:44:55": -60.0}},
+	})
+	assert.False(t, added)
+	inv.Add(SensorData{
+		Timestamp: 3000,
+		Sensors:   map[string]map[string]interface{}{"wifi": {"00:11:22:33:44:55": -80.0}},
+	})
+
+	b := inv["wifi-00:11:22:33:44:55"]
+	assert.Equal(t, int64(1000), b.FirstSeen)
+	assert.Equal(t, int64(3000), b.LastSeen)
+	assert.Equal(t, 3, b.Detections)
+	assert.Equal(t, 2, b.NumLocations)
+	assert.False(t, b.Randomized)
+	assert.Equal(t, -50.0, b.Locations["kitchen"].Mean)
+	assert.True(t, inv["bluetooth-da:a1:19:00:00:01"].Randomized)
+
+	assert.Equal(t, 1, len(inv.Filter("bluetooth")))
+	assert.Equal(t, 2, len(inv.Filter("")))
+
+	// the randomized beacon is removed once it is old, the others are kept
+	assert.Equal(t, 0, inv.Prune(2000))
+	assert.Equal(t, 1, inv.Prune(2001))
+	assert.Equal(t, 1, len(inv))
+}
@@ -0,0 +1,73 @@
+package models
+
+import "time"
+
+// CalibrationPolicy decides when a family is calibrated automatically
+type CalibrationPolicy struct {
+	// NewSamples calibrates after this many new learning fingerprints, 0 disables it
+	NewSamples int `json:"new_samples"`
+	// Nightly calibrates every day at this time in UTC, like "03:00", empty disables it
+	Nightly string `json:"nightly"`
+	// DriftThreshold calibrates when the drift score exceeds it, 0 disables it
+	DriftThreshold float64 `json:"drift_threshold"`
+	// DriftAlert raises an alert when the drift score exceeds it, 0 disables it
+	DriftAlert float64 `json:"drift_alert"`
+	// DebounceMinutes is the minimum time between two automatic calibrations
+	DebounceMinutes int `json:"debounce_minutes"`
+	// StrictLint refuses to calibrate when the learning data has errors
+	StrictLint bool `json:"strict_lint"`
+}
+
+// CalibrationState is the persisted state of the calibrations of a family
+type CalibrationState struct {
+	Running bool `json:"running"`
+	// Trigger is the reason for the last calibration
+	Trigger string `json:"trigger"`
+	// NewSamples is the number of learning fingerprints since the last calibration
+	NewSamples int       `json:"new_samples"`
+	LastStart  time.Time `json:"last_start"`
+	LastFinish time.Time `json:"last_finish"`
+	// LastNightly is the date of the last nightly calibration
+	LastNightly string `json:"last_nightly,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// CalibrationJob is the status of an asynchronous calibration
+type CalibrationJob struct {
+	ID      string `json:"id"`
+	Family  string `json:"family"`
+	Trigger string `json:"trigger"`
+	// Phase is one of export, fit, cross-validate, score, done, failed or cancelled
+	Phase string `json:"phase"`
+	// Analyzed is the number of cross validation fingerprints analyzed
+	Analyzed int `json:"analyzed"`
+	// Total is the number of cross validation fingerprints
+	Total int `json:"total"`
+	// WorkerProgress is the number of fingerprints analyzed by each worker
+	WorkerProgress []int               `json:"worker_progress"`
+	Metrics        *CalibrationMetrics `json:"metrics,omitempty"`
+	Error          string              `json:"error,omitempty"`
+	Created        time.Time           `json:"created"`
+	// Finished is nil while the job is running
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// CalibrationMetrics are the results of a calibration
+type CalibrationMetrics struct {
+	PercentCorrect     float64            `json:"percent_correct"`
+	AccuracyBreakdown  map[string]float64 `json:"accuracy_breakdown"`
+	MinimumProbability float64            `json:"minimum_probability"`
+	// EnsembleAccuracy maps ensemble strategy -> accuracy
+	EnsembleAccuracy map[string]float64 `json:"ensemble_accuracy"`
+	NumLearn         int                `json:"num_learn"`
+	NumTest          int                `json:"num_test"`
+	// MeanPositionError is the mean distance in metres between the estimated
+	// and true positions, for the locations with coordinates
+	MeanPositionError float64 `json:"mean_position_error,omitempty"`
+	// FloorAccuracy is the fraction of the estimated positions on the correct floor
+	FloorAccuracy float64 `json:"floor_accuracy,omitempty"`
+	// NumFeatures is the number of sensors used for learning
+	NumFeatures int `json:"num_features"`
+	// DroppedFeatures maps each dropped sensor -> reason
+	DroppedFeatures map[string]string `json:"dropped_features,omitempty"`
+}
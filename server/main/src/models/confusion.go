@@ -0,0 +1,102 @@
+package models
+
+import "sort"
+
+// histogramBins is the number of bins of the probability histograms
+const histogramBins = 10
+
+// ConfusionMatrix maps true location -> guessed location -> count
+type ConfusionMatrix map[string]map[string]int
+
+// ConfusedPair is a location that is often guessed as another location
+type ConfusedPair struct {
+	Location string `json:"location"`
+	Guessed  string `json:"guessed"`
+	Count    int    `json:"count"`
+	// Fraction is the fraction of the fingerprints of the location that were guessed wrong
+	Fraction float64 `json:"fraction"`
+}
+
+// ProbabilityHistogram counts the probabilities of the best guesses of a
+// location in bins of 0.1, separately for the correct and wrong guesses
+type ProbabilityHistogram struct {
+	Correct   []int `json:"correct"`
+	Incorrect []int `json:"incorrect"`
+}
+
+// ConfusionReport are the diagnostics of a cross validation
+type ConfusionReport struct {
+	// Algorithms maps algorithm -> confusion matrix
+	Algorithms map[string]ConfusionMatrix `json:"algorithms"`
+	Ensemble   ConfusionMatrix            `json:"ensemble"`
+	// MostConfused are the pairs of the ensemble that are confused the most
+	MostConfused []ConfusedPair `json:"most_confused"`
+	// Histograms maps location -> histogram of the ensemble probabilities
+	Histograms map[string]ProbabilityHistogram `json:"histograms"`
+}
+
+// NewConfusionReport builds the diagnostics from the confusion matrices of the
+// cross validation, and the probabilities of the best guesses of the ensemble
+// for each true location, which are negative for the wrong guesses.
+func NewConfusionReport(algorithms map[string]ConfusionMatrix, ensemble ConfusionMatrix, probabilities map[string][]float64, numPairs int) (report ConfusionReport) {
+	report.Algorithms = algorithms
+	report.Ensemble = ensemble
+	report.MostConfused = MostConfused(ensemble, numPairs)
+	report.Histograms = make(map[string]ProbabilityHistogram)
+	for location, probs := range probabilities {
+		h := ProbabilityHistogram{
+			Correct:   make([]int, histogramBins),
+			Incorrect: make([]int, histogramBins),
+		}
+		for _, p := range probs {
+			bins := h.Correct
+			if p < 0 {
+				bins = h.Incorrect
+				p = -p
+			}
+			bin := int(p * histogramBins)
+			if bin >= histogramBins {
+				bin = histogramBins - 1
+			}
+			bins[bin]++
+		}
+		report.Histograms[location] = h
+	}
+	return
+}
+
+// MostConfused returns the wrong guesses of the confusion matrix, the most
+// frequent first. At most n pairs are returned if n > 0.
+func MostConfused(m ConfusionMatrix, n int) (pairs []ConfusedPair) {
+	pairs = []ConfusedPair{}
+	for location := range m {
+		total := 0
+		for guessed := range m[location] {
+			total += m[location][guessed]
+		}
+		for guessed, count := range m[location] {
+			if guessed == location || count == 0 {
+				continue
+			}
+			pairs = append(pairs, ConfusedPair{
+				Location: location,
+				Guessed:  guessed,
+				Count:    count,
+				Fraction: float64(count) / float64(total),
+			})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Count != pairs[j].Count {
+			return pairs[i].Count > pairs[j].Count
+		}
+		if pairs[i].Location != pairs[j].Location {
+			return pairs[i].Location < pairs[j].Location
+		}
+		return pairs[i].Guessed < pairs[j].Guessed
+	})
+	if n > 0 && len(pairs) > n {
+		pairs = pairs[:n]
+	}
+	return
+}
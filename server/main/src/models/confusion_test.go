@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfusionReport(t *testing.T) {
+	ensemble := ConfusionMatrix{
+		"kitchen": {"kitchen": 8, "living": 2, "bedroom": 0},
+		"living":  {"kitchen": 3, "living": 6, "bedroom": 1},
+		"bedroom": {"kitchen": 0, "living": 0, "bedroom": 10},
+	}
+	probabilities := map[string][]float64{
+		"kitchen": {0.95, 0.8, 0.85, -0.4, -0.55},
+		"bedroom": {1, 0.99},
+	}
+	report := NewConfusionReport(map[string]ConfusionMatrix{"SVM": ensemble}, ensemble, probabilities, 2)
+
+	assert.Equal(t, []ConfusedPair{
+		{Location: "living", Guessed: "kitchen", Count: 3, Fraction: 0.3},
+		{Location: "kitchen", Guessed: "living", Count: 2, Fraction: 0.2},
+	}, report.MostConfused)
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 0, 2, 1}, report.Histograms["kitchen"].Correct)
+	assert.Equal(t, []int{0, 0, 0, 0, 1, 1, 0, 0, 0, 0}, report.Histograms["kitchen"].Incorrect)
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 0, 0, 2}, report.Histograms["bedroom"].Correct)
+
+	assert.Equal(t, 3, len(MostConfused(ensemble, 0)))
+}
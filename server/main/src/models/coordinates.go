@@ -0,0 +1,90 @@
+package models
+
+import "math"
+
+// earthRadius is the mean radius of the earth in metres
+const earthRadius = 6371000.0
+
+// Coordinates place a location on a floor plan, in metres
+type Coordinates struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Floor int     `json:"floor"`
+}
+
+// Position is an estimated position with its uncertainty
+type Position struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Floor int     `json:"floor"`
+	// Radius is the uncertainty of the position in metres
+	Radius float64 `json:"radius"`
+}
+
+// Distance returns the distance in metres between two points on the same floor
+func Distance(x1, y1, x2, y2 float64) float64 {
+	return math.Sqrt((x1-x2)*(x1-x2) + (y1-y2)*(y1-y2))
+}
+
+// EstimatePosition returns the probability-weighted centroid of the best k
+// guesses that have coordinates. Only the guesses on the most probable floor
+// are used. The radius is the weighted root mean square distance of the
+// guesses from the centroid. It returns nil if none of the guesses have coordinates.
+func EstimatePosition(guesses []LocationPrediction, coordinates map[string]Coordinates, k int) *Position {
+	if k > 0 && len(guesses) > k {
+		guesses = guesses[:k]
+	}
+	floors := make(map[int]float64)
+	for _, guess := range guesses {
+		if c, ok := coordinates[guess.Location]; ok && guess.Probability > 0 {
+			floors[c.Floor] += guess.Probability
+		}
+	}
+	if len(floors) == 0 {
+		return nil
+	}
+	p := new(Position)
+	best := -1.0
+	for floor, probability := range floors {
+		if probability > best || (probability == best && floor < p.Floor) {
+			best = probability
+			p.Floor = floor
+		}
+	}
+
+	for _, guess := range guesses {
+		if c, ok := coordinates[guess.Location]; ok && c.Floor == p.Floor && guess.Probability > 0 {
+			p.X += guess.Probability * c.X / best
+			p.Y += guess.Probability * c.Y / best
+		}
+	}
+	for _, guess := range guesses {
+		if c, ok := coordinates[guess.Location]; ok && c.Floor == p.Floor && guess.Probability > 0 {
+			d := Distance(p.X, p.Y, c.X, c.Y)
+			p.Radius += guess.Probability * d * d / best
+		}
+	}
+	p.Radius = math.Sqrt(p.Radius)
+	return p
+}
+
+// ProjectGPS converts the GPS positions of locations into coordinates in
+// metres, relative to their mean position. The altitude is ignored.
+func ProjectGPS(gps map[string]GPS) (coordinates map[string]Coordinates) {
+	coordinates = make(map[string]Coordinates)
+	if len(gps) == 0 {
+		return
+	}
+	lat0, lon0 := 0.0, 0.0
+	for _, g := range gps {
+		lat0 += g.Latitude / float64(len(gps))
+		lon0 += g.Longitude / float64(len(gps))
+	}
+	for location, g := range gps {
+		coordinates[location] = Coordinates{
+			X: (g.Longitude - lon0) * math.Pi / 180 * earthRadius * math.Cos(lat0*math.Pi/180),
+			Y: (g.Latitude - lat0) * math.Pi / 180 * earthRadius,
+		}
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimatePosition(t *testing.T) {
+	coordinates := map[string]Coordinates{
+		"kitchen": {X: 0, Y: 0},
+		"living":  {X: 10, Y: 0},
+		"bedroom": {X: 0, Y: 0, Floor: 1},
+	}
+	guesses := []LocationPrediction{
+		{Location: "kitchen", Probability: 0.5},
+		{Location: "living", Probability: 0.3},
+		{Location: "bedroom", Probability: 0.1},
+		{Location: "garden", Probability: 0.1},
+	}
+	p := EstimatePosition(guesses, coordinates, 3)
+	assert.Equal(t, 0, p.Floor)
+	assert.InDelta(t, 3.75, p.X, 0.001)
+	assert.InDelta(t, 0, p.Y, 0.001)
+	assert.InDelta(t, 4.84, p.Radius, 0.01)
+
+	// a single guess is exact
+	p = EstimatePosition(guesses, coordinates, 1)
+	assert.Equal(t, Position{}, *p)
+
+	assert.Nil(t, EstimatePosition([]LocationPrediction{{Location: "garden", Probability: 1}}, coordinates, 3))
+}
+
+func TestProjectGPS(t *testing.T) {
+	coordinates := ProjectGPS(map[string]GPS{
+		"a": {Latitude: 52.0, Longitude: 4.0},
+		"b": {Latitude: 52.0001, Longitude: 4.0},
+	})
+	assert.InDelta(t, 11.1, Distance(coordinates["a"].X, coordinates["a"].Y, coordinates["b"].X, coordinates["b"].Y), 0.1)
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// minimumOffsetSamples is the number of readings needed to estimate an offset
+const minimumOffsetSamples = 10
+
+// RSSISensorTypes are the sensor types whose values are signal strengths
+var RSSISensorTypes = map[string]bool{
+	"wifi":      true,
+	"bluetooth": true,
+}
+
+// DeviceOffset corrects the signal strengths reported by a device,
+// as Scale*rssi + Offset, to match the rest of the family
+type DeviceOffset struct {
+	Offset  float64   `json:"offset"`
+	Scale   float64   `json:"scale"`
+	Samples int       `json:"samples"`
+	Updated time.Time `json:"updated"`
+	// Pinned offsets were reset by hand and are not estimated again
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// Apply returns a copy of the sensor data with corrected signal strengths
+func (o DeviceOffset) Apply(s SensorData) SensorData {
+	if o.Scale == 0 || (o.Scale == 1 && o.Offset == 0) {
+		return s
+	}
+	sensors := make(map[string]map[string]interface{})
+	for sensorType := range s.Sensors {
+		sensors[sensorType] = make(map[string]interface{})
+		for name, value := range s.Sensors[sensorType] {
+			if rssi, ok := value.(float64); ok && RSSISensorTypes[sensorType] {
+				sensors[sensorType][name] = o.Scale*rssi + o.Offset
+			} else {
+				sensors[sensorType][name] = value
+			}
+		}
+	}
+	s.Sensors = sensors
+	return s
+}
+
+// EstimateDeviceOffsets compares the readings of each device at its learned
+// locations with the mean reading of all devices there, and fits the scale
+// and offset that best map one onto the other.
+func EstimateDeviceOffsets(datas []SensorData) (offsets map[string]DeviceOffset) {
+	// sums maps location -> beacon -> device -> [sum, count]
+	sums := make(map[string]map[string]map[string][2]float64)
+	for _, data := range datas {
+		if data.Location == "" {
+			continue
+		}
+		for sensorType := range data.Sensors {
+			if !RSSISensorTypes[sensorType] {
+				continue
+			}
+			for name, value := range data.Sensors[sensorType] {
+				rssi, ok := value.(float64)
+				if !ok {
+					continue
+				}
+				beacon := sensorType + "-" + name
+				if _, ok := sums[data.Location]; !ok {
+					sums[data.Location] = make(map[string]map[string][2]float64)
+				}
+				if _, ok := sums[data.Location][beacon]; !ok {
+					sums[data.Location][beacon] = make(map[string][2]float64)
+				}
+				sum := sums[data.Location][beacon][data.Device]
+				sums[data.Location][beacon][data.Device] = [2]float64{sum[0] + rssi, sum[1] + 1}
+			}
+		}
+	}
+
+	// pair the mean of each device with the mean over all devices
+	pairs := make(map[string][][2]float64)
+	for loc := range sums {
+		for beacon := range sums[loc] {
+			if len(sums[loc][beacon]) < 2 {
+				continue
+			}
+			reference := float64(0)
+			for _, sum := range sums[loc][beacon] {
+				reference += sum[0] / sum[1] / float64(len(sums[loc][beacon]))
+			}
+			for device, sum := range sums[loc][beacon] {
+				pairs[device] = append(pairs[device], [2]float64{sum[0] / sum[1], reference})
+			}
+		}
+	}
+
+	offsets = make(map[string]DeviceOffset)
+	now := time.Now().UTC()
+	for device := range pairs {
+		if len(pairs[device]) < minimumOffsetSamples {
+			continue
+		}
+		offset := fitOffset(pairs[device])
+		offset.Samples = len(pairs[device])
+		offset.Updated = now
+		offsets[device] = offset
+	}
+	return
+}
+
+// fitOffset finds the least squares line reference = Scale*reading + Offset,
+// falling back to only an offset when the scale is not plausible
+func fitOffset(pairs [][2]float64) (o DeviceOffset) {
+	n := float64(len(pairs))
+	meanX, meanY := float64(0), float64(0)
+	for _, p := range pairs {
+		meanX += p[0] / n
+		meanY += p[1] / n
+	}
+	covariance, variance := float64(0), float64(0)
+	for _, p := range pairs {
+		covariance += (p[0] - meanX) * (p[1] - meanY)
+		variance += (p[0] - meanX) * (p[0] - meanX)
+	}
+	o.Scale = 1
+	if variance/n >= 1 {
+		scale := covariance / variance
+		if scale >= 0.5 && scale <= 2 {
+			o.Scale = scale
+		}
+	}
+	o.Offset = meanY - o.Scale*meanX
+	o.Offset = math.Round(o.Offset*100) / 100
+	o.Scale = math.Round(o.Scale*1000) / 1000
+	return
+}
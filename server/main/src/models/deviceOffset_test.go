@@ -0,0 +1,44 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceOffsets(t *testing.T) {
+	datas := []SensorData{}
+	for i := 0; i < 20; i++ {
+		loc := fmt.Sprintf("room%d", i%4)
+		for _, device := range []string{"phone1", "phone2", "phone3"} {
+			s := SensorData{
+				Device:   device,
+				Location: loc,
+				Sensors:  map[string]map[string]interface{}{"wifi": {}, "temperature": {"t": 20.0}},
+			}
+			for j := 0; j < 5; j++ {
+				rssi := float64(-40 - 10*((i+j)%5))
+				if device == "phone3" {
+					// phone3 reports everything 8 dB weaker
+					rssi -= 8
+				}
+				s.Sensors["wifi"][fmt.Sprintf("ap%d", j)] = rssi
+			}
+			datas = append(datas, s)
+		}
+	}
+
+	offsets := EstimateDeviceOffsets(datas)
+	assert.Equal(t, 3, len(offsets))
+	assert.InDelta(t, 1, offsets["phone3"].Scale, 0.01)
+	assert.InDelta(t, 5.33, offsets["phone3"].Offset, 0.1)
+	assert.InDelta(t, -2.67, offsets["phone1"].Offset, 0.1)
+
+	// after correction both phones report the same
+	corrected := offsets["phone3"].Apply(datas[2])
+	assert.InDelta(t, offsets["phone1"].Apply(datas[0]).Sensors["wifi"]["ap0"].(float64), corrected.Sensors["wifi"]["ap0"].(float64), 0.5)
+	assert.Equal(t, 20.0, corrected.Sensors["temperature"]["t"])
+	// the original data is not changed
+	assert.NotEqual(t, corrected.Sensors["wifi"]["ap0"], datas[2].Sensors["wifi"]["ap0"])
+}
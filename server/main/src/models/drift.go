@@ -0,0 +1,273 @@
+package models
+
+import (
+	"math"
+	"sort"
+
+	"github.com/schollz/find3/server/main/src/utils"
+)
+
+// DriftExpectedRate is how often a sensor must be seen at a location to be
+// expected there, so that it counts as disappeared when it is not
+const DriftExpectedRate = 0.5
+
+// DriftShift is the change of mean signal strength, in dB, that counts as a full shift
+const DriftShift = 10.0
+
+// DriftMinFingerprints is the number of tracking fingerprints of a location
+// needed before its drift is scored
+const DriftMinFingerprints = 10
+
+// DriftWindow is the number of tracking fingerprints of a location after
+// which the older observations count half, so the drift follows recent changes
+const DriftWindow = 200
+
+// SensorBaseline is the signal of a sensor at a location during the last calibration
+type SensorBaseline struct {
+	DetectionRate float64 `json:"detection_rate"`
+	Mean          float64 `json:"mean"`
+	SD            float64 `json:"sd"`
+}
+
+// DriftBaseline is the environment of each location during the last calibration
+type DriftBaseline struct {
+	// Locations maps location -> sensor -> signal
+	Locations map[string]map[string]SensorBaseline `json:"locations"`
+	// Sensors are all the sensors seen during calibration
+	Sensors map[string]struct{} `json:"sensors"`
+}
+
+// SensorDrift is a sensor that disappeared, appeared or shifted at a location
+type SensorDrift struct {
+	Sensor string `json:"sensor"`
+	// Expected is the detection rate or mean signal strength during calibration
+	Expected float64 `json:"expected"`
+	// Observed is the detection rate or mean signal strength since then
+	Observed float64 `json:"observed"`
+}
+
+// LocationDrift is how much the environment of a location changed since calibration
+type LocationDrift struct {
+	// Score is between 0 for no change and 1 for a different environment
+	Score        float64       `json:"score"`
+	Fingerprints int           `json:"fingerprints"`
+	Disappeared  []SensorDrift `json:"disappeared,omitempty"`
+	Appeared     []SensorDrift `json:"appeared,omitempty"`
+	Shifted      []SensorDrift `json:"shifted,omitempty"`
+}
+
+// DriftReport is how much the environment of a family changed since calibration
+type DriftReport struct {
+	// Score is the mean score of the locations, weighted by their fingerprints
+	Score     float64                  `json:"score"`
+	Locations map[string]LocationDrift `json:"locations"`
+}
+
+// driftSensor returns the name of a sensor that is compared, or false for
+// sensors that are not signal strengths or whose address changes by design
+func driftSensor(sensorType, name string, value interface{}) (sensor string, rssi float64, ok bool) {
+	if !RSSISensorTypes[sensorType] || utils.IsMacRandomized(name) {
+		return
+	}
+	rssi, ok = value.(float64)
+	return sensorType + "-" + name, rssi, ok
+}
+
+// NewDriftBaseline summarizes the learning data of each location
+func NewDriftBaseline(datas []SensorData) (b DriftBaseline) {
+	b.Locations = make(map[string]map[string]SensorBaseline)
+	b.Sensors = make(map[string]struct{})
+	counts := make(map[string]int)
+	values := make(map[string]map[string][]float64)
+	for _, data := range datas {
+		if data.Location == "" {
+			continue
+		}
+		counts[data.Location]++
+		if _, ok := values[data.Location]; !ok {
+			values[data.Location] = make(map[string][]float64)
+		}
+		for sensorType := range data.Sensors {
+			for name, value := range data.Sensors[sensorType] {
+				sensor, rssi, ok := driftSensor(sensorType, name, value)
+				if !ok {
+					continue
+				}
+				b.Sensors[sensor] = struct{}{}
+				values[data.Location][sensor] = append(values[data.Location][sensor], rssi)
+			}
+		}
+	}
+	for location := range values {
+		b.Locations[location] = make(map[string]SensorBaseline)
+		for sensor, vs := range values[location] {
+			mean, sd := meanSD(vs)
+			b.Locations[location][sensor] = SensorBaseline{
+				DetectionRate: float64(len(vs)) / float64(counts[location]),
+				Mean:          mean,
+				SD:            sd,
+			}
+		}
+	}
+	return
+}
+
+func meanSD(vs []float64) (mean, sd float64) {
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(len(vs))
+	for _, v := range vs {
+		sd += (v - mean) * (v - mean)
+	}
+	sd = math.Sqrt(sd / float64(len(vs)))
+	return
+}
+
+// sensorObservation sums the signal of a sensor at a location
+type sensorObservation struct {
+	Count float64
+	Sum   float64
+}
+
+// DriftMonitor collects the tracking fingerprints of each guessed location
+type DriftMonitor struct {
+	// Fingerprints maps location -> number of fingerprints, halved with the
+	// sensors when it reaches the window
+	Fingerprints map[string]float64
+	Sensors      map[string]map[string]sensorObservation
+	// Observed is the number of fingerprints since the monitor was made
+	Observed int
+}
+
+// NewDriftMonitor returns a monitor without observations
+func NewDriftMonitor() *DriftMonitor {
+	return &DriftMonitor{
+		Fingerprints: make(map[string]float64),
+		Sensors:      make(map[string]map[string]sensorObservation),
+	}
+}
+
+// Observe adds a tracking fingerprint that was guessed to be at the location
+func (m *DriftMonitor) Observe(location string, data SensorData) {
+	if location == "" || location == "?" {
+		return
+	}
+	if _, ok := m.Sensors[location]; !ok {
+		m.Sensors[location] = make(map[string]sensorObservation)
+	}
+	m.Observed++
+	m.Fingerprints[location]++
+	for sensorType := range data.Sensors {
+		for name, value := range data.Sensors[sensorType] {
+			sensor, rssi, ok := driftSensor(sensorType, name, value)
+			if !ok {
+				continue
+			}
+			o := m.Sensors[location][sensor]
+			o.Count++
+			o.Sum += rssi
+			m.Sensors[location][sensor] = o
+		}
+	}
+	if m.Fingerprints[location] >= DriftWindow {
+		m.Fingerprints[location] /= 2
+		for sensor, o := range m.Sensors[location] {
+			m.Sensors[location][sensor] = sensorObservation{Count: o.Count / 2, Sum: o.Sum / 2}
+		}
+	}
+}
+
+// Report compares the observations with the baseline. The score of a
+// location is the mean of the fraction of expected detections that
+// disappeared, the fraction of detections of sensors that were never seen
+// during calibration, and the mean shift of the signals of the other sensors.
+func (m *DriftMonitor) Report(b DriftBaseline) (report DriftReport) {
+	report.Locations = make(map[string]LocationDrift)
+	total := 0.0
+	for location, n := range m.Fingerprints {
+		expected, ok := b.Locations[location]
+		if !ok || n < DriftMinFingerprints {
+			continue
+		}
+		drift := LocationDrift{Fingerprints: int(n)}
+		observed := m.Sensors[location]
+
+		// disappeared sensors
+		missing, expectedRate := 0.0, 0.0
+		for sensor, base := range expected {
+			if base.DetectionRate < DriftExpectedRate {
+				continue
+			}
+			rate := observed[sensor].Count / n
+			expectedRate += base.DetectionRate
+			if rate < base.DetectionRate {
+				missing += base.DetectionRate - rate
+			}
+			if rate < base.DetectionRate/2 {
+				drift.Disappeared = append(drift.Disappeared, SensorDrift{Sensor: sensor, Expected: base.DetectionRate, Observed: rate})
+			}
+		}
+		disappeared := 0.0
+		if expectedRate > 0 {
+			disappeared = missing / expectedRate
+		}
+
+		// appeared sensors and shifted signals
+		detections, newDetections, shift, shifted := 0.0, 0.0, 0.0, 0
+		for sensor, o := range observed {
+			if o.Count == 0 {
+				continue
+			}
+			detections += o.Count
+			rate := o.Count / n
+			if _, known := b.Sensors[sensor]; !known {
+				newDetections += o.Count
+				if rate >= DriftExpectedRate {
+					drift.Appeared = append(drift.Appeared, SensorDrift{Sensor: sensor, Observed: rate})
+				}
+				continue
+			}
+			base, ok := expected[sensor]
+			if !ok {
+				continue
+			}
+			mean := o.Sum / o.Count
+			shift += math.Min(1, math.Abs(mean-base.Mean)/DriftShift)
+			shifted++
+			if math.Abs(mean-base.Mean) >= DriftShift/2 {
+				drift.Shifted = append(drift.Shifted, SensorDrift{Sensor: sensor, Expected: base.Mean, Observed: mean})
+			}
+		}
+		appeared := 0.0
+		if detections > 0 {
+			appeared = newDetections / detections
+		}
+		if shifted > 0 {
+			shift /= float64(shifted)
+		}
+
+		drift.Score = (disappeared + appeared + shift) / 3
+		sortSensorDrifts(drift.Disappeared)
+		sortSensorDrifts(drift.Appeared)
+		sortSensorDrifts(drift.Shifted)
+		report.Locations[location] = drift
+		report.Score += drift.Score * n
+		total += n
+	}
+	if total > 0 {
+		report.Score /= total
+	}
+	return
+}
+
+func sortSensorDrifts(drifts []SensorDrift) {
+	sort.Slice(drifts, func(i, j int) bool {
+		di := math.Abs(drifts[i].Expected - drifts[i].Observed)
+		dj := math.Abs(drifts[j].Expected - drifts[j].Observed)
+		if di == dj {
+			return drifts[i].Sensor < drifts[j].Sensor
+		}
+		return di > dj
+	})
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func driftData(location string, wifi map[string]interface{}) SensorData {
+	return SensorData{Location: location, Sensors: map[string]map[string]interface{}{"wifi": wifi}}
+}
+
+func TestDrift(t *testing.T) {
+	var datas []SensorData
+	for i := 0; i < 20; i++ {
+		datas = append(datas, driftData("kitchen", map[string]interface{}{"a": -50.0, "b": -60.0}))
+	}
+	b := NewDriftBaseline(datas)
+	assert.Equal(t, SensorBaseline{DetectionRate: 1, Mean: -50}, b.Locations["kitchen"]["wifi-a"])
+
+	// nothing changed
+	m := NewDriftMonitor()
+	for i := 0; i < 20; i++ {
+		m.Observe("kitchen", driftData("", map[string]interface{}{"a": -50.0, "b": -60.0}))
+	}
+	report := m.Report(b)
+	assert.Equal(t, 0.0, report.Score)
+	assert.Equal(t, 20, report.Locations["kitchen"].Fingerprints)
+
+	// b died, c was installed and a moved
+	m = NewDriftMonitor()
+	for i := 0; i < 5; i++ {
+		m.Observe("kitchen", driftData("", map[string]interface{}{"a": -55.0, "c": -70.0}))
+	}
+	assert.Equal(t, 0, len(m.Report(b).Locations), "too few fingerprints to score")
+	for i := 0; i < 15; i++ {
+		m.Observe("kitchen", driftData("", map[string]interface{}{"a": -55.0, "c": -70.0}))
+	}
+	m.Observe("?", driftData("", map[string]interface{}{"d": -70.0}))
+	report = m.Report(b)
+	drift := report.Locations["kitchen"]
+	// half the expected detections disappeared, half the detections are new, a shifted by half
+	assert.InDelta(t, (0.5+0.5+0.5)/3, drift.Score, 1e-9)
+	assert.Equal(t, drift.Score, report.Score)
+	assert.Equal(t, []SensorDrift{{Sensor: "wifi-b", Expected: 1, Observed: 0}}, drift.Disappeared)
+	assert.Equal(t, []SensorDrift{{Sensor: "wifi-c", Observed: 1}}, drift.Appeared)
+	assert.Equal(t, []SensorDrift{{Sensor: "wifi-a", Expected: -50, Observed: -55}}, drift.Shifted)
+}
+
+func TestDriftWindow(t *testing.T) {
+	m := NewDriftMonitor()
+	for i := 0; i < DriftWindow; i++ {
+		m.Observe("kitchen", driftData("", map[string]interface{}{"a": -50.0}))
+	}
+	assert.Equal(t, float64(DriftWindow/2), m.Fingerprints["kitchen"])
+	assert.Equal(t, float64(DriftWindow/2), m.Sensors["kitchen"]["wifi-a"].Count)
+	assert.Equal(t, DriftWindow, m.Observed)
+}
@@ -0,0 +1,33 @@
+package models
+
+// Explanation tells why the locations were guessed
+type Explanation struct {
+	// Locations explain the best guesses with the Go classifier
+	Locations []LocationExplanation `json:"locations"`
+	// Weights maps algorithm -> location -> the weight the ensemble gave to
+	// the probability of the location, for the ensembles that weight them
+	Weights map[string]map[string]float64 `json:"weights,omitempty"`
+}
+
+// LocationExplanation tells how the sensors of a fingerprint count for a location
+type LocationExplanation struct {
+	Location string `json:"location"`
+	// Contributions are what each sensor adds to the log probability of the location, most first
+	Contributions []SensorContribution `json:"contributions"`
+	// Missing are the sensors usually seen at the location that are not in the fingerprint
+	Missing []MissingSensor `json:"missing,omitempty"`
+}
+
+// SensorContribution is what a sensor adds to the log probability of a location
+type SensorContribution struct {
+	Sensor         string  `json:"sensor"`
+	Value          float64 `json:"value"`
+	LogProbability float64 `json:"log_probability"`
+}
+
+// MissingSensor is a sensor that was expected at a location but not seen
+type MissingSensor struct {
+	Sensor string `json:"sensor"`
+	// DetectionRate is the fraction of the learned fingerprints of the location with the sensor
+	DetectionRate float64 `json:"detection_rate"`
+}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/schollz/find3/server/main/src/utils"
+)
+
+// FeatureSettings decide which sensors are used for learning
+type FeatureSettings struct {
+	// MinimumDetectionRate drops sensors that are not seen in at least this
+	// fraction of the fingerprints of any location
+	MinimumDetectionRate float64 `json:"minimum_detection_rate"`
+	// MinimumVariance drops sensors whose values hardly change between fingerprints
+	MinimumVariance float64 `json:"minimum_variance"`
+	// DropRandomized drops wifi and bluetooth sensors with randomized MAC addresses
+	DropRandomized bool `json:"drop_randomized"`
+	// Blacklist are sensors that are never used, like "wifi-aa:bb:cc:dd:ee:ff" or "aa:bb:cc:dd:ee:ff"
+	Blacklist []string `json:"blacklist"`
+}
+
+// FeatureSet is the set of sensors chosen during calibration
+type FeatureSet struct {
+	// Features maps sensor type -> sensor names that are used
+	Features map[string][]string `json:"features"`
+	// Dropped maps "type-name" of each dropped sensor -> reason
+	Dropped map[string]string `json:"dropped"`
+}
+
+// NumFeatures returns the number of sensors that are used
+func (f FeatureSet) NumFeatures() (n int) {
+	for sensorType := range f.Features {
+		n += len(f.Features[sensorType])
+	}
+	return
+}
+
+// Apply returns a copy of the sensor data with only the chosen sensors. A
+// feature set that was never selected keeps all the sensors.
+func (f FeatureSet) Apply(s SensorData) SensorData {
+	if f.Features == nil {
+		return s
+	}
+	sensors := make(map[string]map[string]interface{})
+	for sensorType, names := range f.Features {
+		if _, ok := s.Sensors[sensorType]; !ok {
+			continue
+		}
+		sensors[sensorType] = make(map[string]interface{})
+		for _, name := range names {
+			if value, ok := s.Sensors[sensorType][name]; ok {
+				sensors[sensorType][name] = value
+			}
+		}
+	}
+	s.Sensors = sensors
+	return s
+}
+
+// SelectFeatures chooses the sensors of the learning data that are useful
+// for telling the locations apart. Missing readings count as zero when
+// computing the variance, as they do for the machine learning.
+func SelectFeatures(datas []SensorData, settings FeatureSettings) (f FeatureSet) {
+	f.Features = make(map[string][]string)
+	f.Dropped = make(map[string]string)
+
+	blacklist := make(map[string]bool)
+	for _, name := range settings.Blacklist {
+		blacklist[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	// seen maps "type-name" -> location -> number of fingerprints
+	seen := make(map[string]map[string]int)
+	locationTotals := make(map[string]int)
+	sums := make(map[string][2]float64)
+	// categorical sensors have no variance
+	categorical := make(map[string]bool)
+	for _, data := range datas {
+		locationTotals[data.Location]++
+		for sensorType := range data.Sensors {
+			for name, value := range data.Sensors[sensorType] {
+				feature := fmt.Sprintf("%s-%s", sensorType, name)
+				if _, ok := seen[feature]; !ok {
+					seen[feature] = make(map[string]int)
+				}
+				seen[feature][data.Location]++
+				v, err := ParseSensorValue(sensorType, value)
+				if err != nil || v.Kind == CategoricalValue {
+					categorical[feature] = true
+					continue
+				}
+				sum := sums[feature]
+				sums[feature] = [2]float64{sum[0] + v.Number, sum[1] + v.Number*v.Number}
+			}
+		}
+	}
+
+	checked := make(map[string]bool)
+	for _, data := range datas {
+		for sensorType := range data.Sensors {
+			for name := range data.Sensors[sensorType] {
+				feature := fmt.Sprintf("%s-%s", sensorType, name)
+				if checked[feature] {
+					continue
+				}
+				checked[feature] = true
+				if reason := dropReason(sensorType, name, feature, settings, blacklist, seen[feature], locationTotals, sums[feature], categorical[feature], len(datas)); reason != "" {
+					f.Dropped[feature] = reason
+					continue
+				}
+				f.Features[sensorType] = append(f.Features[sensorType], name)
+			}
+		}
+	}
+	for sensorType := range f.Features {
+		sort.Strings(f.Features[sensorType])
+	}
+	return
+}
+
+func dropReason(sensorType, name, feature string, settings FeatureSettings, blacklist map[string]bool, seen map[string]int, locationTotals map[string]int, sum [2]float64, categorical bool, total int) string {
+	if blacklist[strings.ToLower(feature)] || blacklist[strings.ToLower(name)] {
+		return "blacklisted"
+	}
+	if settings.DropRandomized && RSSISensorTypes[sensorType] && utils.IsMacRandomized(name) {
+		return "randomized"
+	}
+	detectionRate := 0.0
+	for location, count := range seen {
+		if rate := float64(count) / float64(locationTotals[location]); rate > detectionRate {
+			detectionRate = rate
+		}
+	}
+	if detectionRate < settings.MinimumDetectionRate {
+		return fmt.Sprintf("detected in %2.0f%% of fingerprints", detectionRate*100)
+	}
+	if settings.MinimumVariance > 0 && total > 1 && !categorical {
+		mean := sum[0] / float64(total)
+		variance := sum[1]/float64(total) - mean*mean
+		if variance < settings.MinimumVariance {
+			return fmt.Sprintf("variance of %2.2f", variance)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectFeatures(t *testing.T) {
+	datas := []SensorData{}
+	for i := 0; i < 20; i++ {
+		s := SensorData{
+			Location: fmt.Sprintf("room%d", i%2),
+			Sensors: map[string]map[string]interface{}{
+				"wifi": {
+					"00:00:00:00:00:01": float64(-40 - 30*(i%2)),
+					"00:00:00:00:00:02": -60.0,
+					// a phone hotspot
+					"62:00:00:00:00:03": float64(-50 - 10*(i%2)),
+					"00:00:00:00:00:04": -70.0,
+				},
+				"door": {
+					"front": []string{"open", "closed"}[i%2],
+				},
+			},
+		}
+		if i == 0 {
+			s.Sensors["wifi"]["00:00:00:00:00:05"] = -90.0
+		}
+		datas = append(datas, s)
+	}
+
+	f := SelectFeatures(datas, FeatureSettings{
+		MinimumDetectionRate: 0.2,
+		MinimumVariance:      1,
+		DropRandomized:       true,
+		Blacklist:            []string{"wifi-00:00:00:00:00:04"},
+	})
+	assert.Equal(t, []string{"00:00:00:00:00:01"}, f.Features["wifi"])
+	// categories have no variance to check
+	assert.Equal(t, []string{"front"}, f.Features["door"])
+	assert.Equal(t, 2, f.NumFeatures())
+	assert.Equal(t, "variance of 0.00", f.Dropped["wifi-00:00:00:00:00:02"])
+	assert.Equal(t, "randomized", f.Dropped["wifi-62:00:00:00:00:03"])
+	assert.Equal(t, "blacklisted", f.Dropped["wifi-00:00:00:00:00:04"])
+	assert.Equal(t, "detected in 10% of fingerprints", f.Dropped["wifi-00:00:00:00:00:05"])
+
+	// only the chosen sensors are kept
+	s := f.Apply(datas[0])
+	assert.Equal(t, 1, len(s.Sensors["wifi"]))
+	assert.Equal(t, 5, len(datas[0].Sensors["wifi"]))
+
+	// a feature set that was never selected keeps everything
+	s = FeatureSet{}.Apply(datas[0])
+	assert.Equal(t, 5, len(s.Sensors["wifi"]))
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RoomLevel is the name of the lowest level of a location hierarchy, the learned locations
+const RoomLevel = "room"
+
+// NodeSeparator joins the names of a location and its ancestors into its key
+const NodeSeparator = "/"
+
+// LocationHierarchy organizes the locations of a family into levels,
+// like site -> building -> floor -> room
+type LocationHierarchy struct {
+	// Levels name the levels above the rooms, from the top, like ["building", "floor"]
+	Levels []string `json:"levels"`
+	// Paths maps each room -> its ancestor at each level, like ["main", "first floor"]
+	Paths map[string][]string `json:"paths"`
+}
+
+// LevelGuess is the best guess at a level of the hierarchy
+type LevelGuess struct {
+	Level    string `json:"level"`
+	Location string `json:"location"`
+	// Probability is the probability of the location and all its ancestors
+	Probability float64 `json:"probability"`
+}
+
+// Validate will validate that the hierarchy is a tree
+func (h LocationHierarchy) Validate() (err error) {
+	levels := make(map[string]struct{})
+	for _, level := range h.Levels {
+		if level == "" || level == RoomLevel {
+			return fmt.Errorf("invalid level name '%s'", level)
+		}
+		if _, ok := levels[level]; ok {
+			return fmt.Errorf("level '%s' is used twice", level)
+		}
+		levels[level] = struct{}{}
+	}
+	if len(h.Levels) > 0 && len(h.Paths) == 0 {
+		return errors.New("hierarchy has no rooms")
+	}
+	for room, path := range h.Paths {
+		if len(path) != len(h.Levels) {
+			return fmt.Errorf("room '%s' needs a location at each of the %d levels", room, len(h.Levels))
+		}
+		for i, node := range path {
+			if node == "" {
+				return fmt.Errorf("room '%s' has an empty %s", room, h.Levels[i])
+			}
+			if strings.Contains(node, NodeSeparator) {
+				return fmt.Errorf("%s '%s' cannot contain '%s'", h.Levels[i], node, NodeSeparator)
+			}
+		}
+	}
+	return
+}
+
+// Node returns the key of the ancestor of a room at a level, which is the
+// path to it like "main/first floor", so that locations with the same name
+// in different parents are different
+func (h LocationHierarchy) Node(room string, level int) string {
+	return strings.Join(h.Paths[room][:level+1], NodeSeparator)
+}
+
+// Nodes returns the keys of the locations at a level of the hierarchy
+func (h LocationHierarchy) Nodes(level int) (nodes []string) {
+	seen := make(map[string]struct{})
+	for room := range h.Paths {
+		node := h.Node(room, level)
+		if _, ok := seen[node]; !ok {
+			seen[node] = struct{}{}
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Strings(nodes)
+	return
+}
+
+// Relabel returns copies of the learning data labeled with the key of their
+// ancestor at a level of the hierarchy. Data of rooms outside the hierarchy
+// is left out.
+func (h LocationHierarchy) Relabel(datas []SensorData, level int) (relabeled []SensorData) {
+	relabeled = make([]SensorData, 0, len(datas))
+	for _, data := range datas {
+		if _, ok := h.Paths[data.Location]; !ok {
+			continue
+		}
+		data.Location = h.Node(data.Location, level)
+		relabeled = append(relabeled, data)
+	}
+	return
+}
+
+// Descend picks the best location at each level, from the guesses of the
+// model of each level, which are keys, and of the rooms. Each level only
+// considers the children of the location picked above it. It stops at the
+// first level where none of the children were guessed.
+func (h LocationHierarchy) Descend(levelGuesses [][]LocationPrediction, roomGuesses []LocationPrediction) (path []LevelGuess) {
+	path = []LevelGuess{}
+	parent := ""
+	probability := 1.0
+	for i := 0; i <= len(h.Levels); i++ {
+		level := RoomLevel
+		guesses := roomGuesses
+		if i < len(h.Levels) {
+			level = h.Levels[i]
+			guesses = levelGuesses[i]
+		}
+		total := 0.0
+		var best LocationPrediction
+		for _, guess := range guesses {
+			if i > 0 && h.parent(i, guess.Location) != parent {
+				continue
+			}
+			total += guess.Probability
+			if guess.Probability > best.Probability {
+				best = guess
+			}
+		}
+		if total <= 0 {
+			return
+		}
+		probability *= best.Probability / total
+		name := best.Location
+		if i < len(h.Levels) {
+			name = name[strings.LastIndex(name, NodeSeparator)+1:]
+		}
+		path = append(path, LevelGuess{Level: level, Location: name, Probability: probability})
+		parent = best.Location
+	}
+	return
+}
+
+// parent returns the key of the location at the level above a location at
+// a level, where the level below the last is the rooms
+func (h LocationHierarchy) parent(level int, location string) string {
+	if level == len(h.Levels) {
+		if _, ok := h.Paths[location]; ok {
+			return h.Node(location, level-1)
+		}
+		return ""
+	}
+	if i := strings.LastIndex(location, NodeSeparator); i >= 0 {
+		return location[:i]
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationHierarchy(t *testing.T) {
+	h := LocationHierarchy{
+		Levels: []string{"building", "floor"},
+		Paths: map[string][]string{
+			"kitchen": {"house", "ground"},
+			"living":  {"house", "ground"},
+			"bedroom": {"house", "upstairs"},
+			"bath":    {"house", "upstairs"},
+			"office":  {"work", "third"},
+		},
+	}
+	assert.Nil(t, h.Validate())
+	assert.Equal(t, []string{"house/ground", "house/upstairs", "work/third"}, h.Nodes(1))
+
+	relabeled := h.Relabel([]SensorData{{Location: "bath"}, {Location: "garage"}}, 1)
+	assert.Equal(t, []SensorData{{Location: "house/upstairs"}}, relabeled)
+
+	// the room is uncertain, but the floor is not
+	path := h.Descend([][]LocationPrediction{
+		{{Location: "house", Probability: 1}},
+		{{Location: "house/upstairs", Probability: 0.8}, {Location: "house/ground", Probability: 0.2}},
+	}, []LocationPrediction{
+		{Location: "kitchen", Probability: 0.4},
+		{Location: "bedroom", Probability: 0.35},
+		{Location: "bath", Probability: 0.25},
+	})
+	assert.Equal(t, 3, len(path))
+	assert.Equal(t, LevelGuess{Level: "floor", Location: "upstairs", Probability: 0.8}, path[1])
+	assert.Equal(t, "bedroom", path[2].Location)
+	assert.InDelta(t, 0.8*0.35/0.6, path[2].Probability, 0.001)
+
+	// an unknown room still has a floor
+	path = h.Descend([][]LocationPrediction{
+		{{Location: "house", Probability: 1}},
+		{{Location: "house/upstairs", Probability: 0.8}, {Location: "house/ground", Probability: 0.2}},
+	}, []LocationPrediction{{Location: "?", Probability: 1}})
+	assert.Equal(t, 2, len(path))
+
+	// the same name in different buildings is a different floor
+	h.Paths["cellar"] = []string{"work", "ground"}
+	assert.Nil(t, h.Validate())
+	assert.Equal(t, []string{"house/ground", "house/upstairs", "work/ground", "work/third"}, h.Nodes(1))
+	path = h.Descend([][]LocationPrediction{
+		{{Location: "work", Probability: 0.9}, {Location: "house", Probability: 0.1}},
+		{{Location: "house/ground", Probability: 0.6}, {Location: "work/ground", Probability: 0.4}},
+	}, []LocationPrediction{{Location: "kitchen", Probability: 0.5}, {Location: "cellar", Probability: 0.5}})
+	assert.Equal(t, 3, len(path))
+	assert.Equal(t, LevelGuess{Level: "floor", Location: "ground", Probability: 0.9}, path[1])
+	assert.Equal(t, "cellar", path[2].Location)
+
+	h.Paths["attic"] = []string{"house", "upstairs/attic"}
+	assert.NotNil(t, h.Validate())
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// LearningSession saves the tracking fingerprints of a device as learning
+// data for a location, until it has collected enough or it times out
+type LearningSession struct {
+	Device   string `json:"device"`
+	Location string `json:"location"`
+	// Target is the number of fingerprints to collect
+	Target    int `json:"target"`
+	Collected int `json:"collected"`
+	// Status is "learning", "done", "timed out" or "stopped"
+	Status   string    `json:"status"`
+	Started  time.Time `json:"started"`
+	Deadline time.Time `json:"deadline"`
+	// Finished is nil while the device is learning
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+// NewLearningSession starts collecting target fingerprints of the device at
+// the location, for at most the timeout
+func NewLearningSession(device string, location string, target int, timeout time.Duration, now time.Time) (l LearningSession, err error) {
+	if device == "" {
+		err = errors.New("device cannot be empty")
+		return
+	}
+	if location == "" {
+		err = errors.New("location cannot be empty")
+		return
+	}
+	if target <= 0 {
+		err = errors.New("target must be positive")
+		return
+	}
+	if timeout <= 0 {
+		err = errors.New("timeout must be positive")
+		return
+	}
+	l = LearningSession{
+		Device:   device,
+		Location: location,
+		Target:   target,
+		Status:   "learning",
+		Started:  now,
+		Deadline: now.Add(timeout),
+	}
+	return
+}
+
+// Active returns whether the session is still learning at the time, and
+// times it out if it is past its deadline
+func (l *LearningSession) Active(now time.Time) bool {
+	if l.Status != "learning" {
+		return false
+	}
+	if now.After(l.Deadline) {
+		l.finish("timed out", now)
+		return false
+	}
+	return true
+}
+
+// Add counts a fingerprint of the device, and returns whether it belongs to
+// the session. The session is done once it reaches its target.
+func (l *LearningSession) Add(now time.Time) bool {
+	if !l.Active(now) {
+		return false
+	}
+	l.Collected++
+	if l.Collected >= l.Target {
+		l.finish("done", now)
+	}
+	return true
+}
+
+// Stop ends the session before it is done
+func (l *LearningSession) Stop(now time.Time) {
+	if l.Active(now) {
+		l.finish("stopped", now)
+	}
+}
+
+// Progress is the fraction of the target that was collected
+func (l LearningSession) Progress() float64 {
+	if l.Target <= 0 {
+		return 0
+	}
+	if l.Collected >= l.Target {
+		return 1
+	}
+	return float64(l.Collected) / float64(l.Target)
+}
+
+func (l *LearningSession) finish(status string, now time.Time) {
+	l.Status = status
+	l.Finished = &now
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLearningSession(t *testing.T) {
+	now := time.Date(2018, 3, 7, 12, 0, 0, 0, time.UTC)
+	_, err := NewLearningSession("phone", "", 10, time.Minute, now)
+	assert.NotNil(t, err)
+	_, err = NewLearningSession("phone", "kitchen", 0, time.Minute, now)
+	assert.NotNil(t, err)
+
+	l, err := NewLearningSession("phone", "kitchen", 2, time.Minute, now)
+	assert.Nil(t, err)
+	assert.True(t, l.Add(now.Add(time.Second)))
+	assert.Equal(t, 0.5, l.Progress())
+	assert.True(t, l.Add(now.Add(2*time.Second)))
+	assert.Equal(t, "done", l.Status)
+	assert.Equal(t, 1.0, l.Progress())
+	// fingerprints after the target are tracking again
+	assert.False(t, l.Add(now.Add(3*time.Second)))
+	assert.Equal(t, 2, l.Collected)
+
+	l, _ = NewLearningSession("phone", "kitchen", 10, time.Minute, now)
+	assert.True(t, l.Add(now.Add(30*time.Second)))
+	assert.False(t, l.Add(now.Add(2*time.Minute)))
+	assert.Equal(t, "timed out", l.Status)
+	assert.Equal(t, 1, l.Collected)
+
+	l, _ = NewLearningSession("phone", "kitchen", 10, time.Minute, now)
+	l.Stop(now.Add(time.Second))
+	assert.Equal(t, "stopped", l.Status)
+	assert.False(t, l.Active(now.Add(2*time.Second)))
+}
@@ -0,0 +1,263 @@
+package models
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+const (
+	// lintMinimumSamples is the number of fingerprints a location needs to be learned at all
+	lintMinimumSamples = 2
+	// lintRecommendedSamples is the number of fingerprints a location should have
+	lintRecommendedSamples = 10
+	// lintMinimumSensors is the number of sensors a fingerprint should have
+	lintMinimumSensors = 2
+	// lintDuplicateDistance is the mean difference under which two fingerprints are duplicates
+	lintDuplicateDistance = 0.5
+	// lintDuplicateBucket is the width of the values, in dB, that are hashed together to find duplicates
+	lintDuplicateBucket = 1.0
+	// lintOutlierDeviations is how many standard deviations from the centroid make an outlier
+	lintOutlierDeviations = 3.0
+	// lintImbalanceRatio is the ratio of the largest and smallest location that is imbalanced
+	lintImbalanceRatio = 5.0
+	// lintVanishedDetectionRate is the detection rate of a beacon that should still be seen
+	lintVanishedDetectionRate = 0.5
+)
+
+// LintIssue is a problem with the learning data
+type LintIssue struct {
+	// Severity is "error" or "warning"
+	Severity string `json:"severity"`
+	// Check is one of samples, sensors, duplicates, outliers, imbalance or vanished
+	Check    string `json:"check"`
+	Location string `json:"location,omitempty"`
+	Message  string `json:"message"`
+	// Timestamps are the fingerprints with the problem
+	Timestamps []int64 `json:"timestamps,omitempty"`
+}
+
+// LintReport are the problems found with the learning data of a family
+type LintReport struct {
+	Issues   []LintIssue `json:"issues"`
+	Errors   int         `json:"errors"`
+	Warnings int         `json:"warnings"`
+}
+
+// HasErrors returns whether the learning data should not be calibrated
+func (r LintReport) HasErrors() bool {
+	return r.Errors > 0
+}
+
+func (r *LintReport) add(issue LintIssue) {
+	if issue.Severity == "error" {
+		r.Errors++
+	} else {
+		r.Warnings++
+	}
+	r.Issues = append(r.Issues, issue)
+}
+
+// Lint checks the learning fingerprints for problems that make a calibration
+// worse. The recent tracking fingerprints are used to find the beacons that
+// have vanished since learning, and can be empty to skip that check.
+func Lint(datas []SensorData, recent []SensorData) (report LintReport) {
+	report.Issues = []LintIssue{}
+	if len(datas) == 0 {
+		report.add(LintIssue{Severity: "error", Check: "samples", Message: "there are no learning fingerprints"})
+		return
+	}
+
+	byLocation := make(map[string][]SensorData)
+	for _, data := range datas {
+		byLocation[data.Location] = append(byLocation[data.Location], data)
+	}
+	locations := make([]string, 0, len(byLocation))
+	for location := range byLocation {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	smallest, largest := "", ""
+	for _, location := range locations {
+		fingerprints := byLocation[location]
+		if smallest == "" || len(fingerprints) < len(byLocation[smallest]) {
+			smallest = location
+		}
+		if largest == "" || len(fingerprints) > len(byLocation[largest]) {
+			largest = location
+		}
+
+		if len(fingerprints) < lintMinimumSamples {
+			report.add(LintIssue{Severity: "error", Check: "samples", Location: location,
+				Message: fmt.Sprintf("only %d fingerprints, cannot be learned", len(fingerprints))})
+		} else if len(fingerprints) < lintRecommendedSamples {
+			report.add(LintIssue{Severity: "warning", Check: "samples", Location: location,
+				Message: fmt.Sprintf("only %d fingerprints, %d are recommended", len(fingerprints), lintRecommendedSamples)})
+		}
+
+		var sparse []int64
+		for _, data := range fingerprints {
+			if numSensors(data) < lintMinimumSensors {
+				sparse = append(sparse, data.Timestamp)
+			}
+		}
+		if len(sparse) > 0 {
+			report.add(LintIssue{Severity: "warning", Check: "sensors", Location: location, Timestamps: sparse,
+				Message: fmt.Sprintf("%d fingerprints have less than %d sensors", len(sparse), lintMinimumSensors)})
+		}
+
+		vectors := make([]map[string]float64, len(fingerprints))
+		for i := range fingerprints {
+			vectors[i] = fingerprintVector(fingerprints[i])
+		}
+
+		// only the fingerprints in the same bucket are compared, so that
+		// this is not quadratic in the number of fingerprints
+		var duplicates []int64
+		buckets := make(map[uint64][]int)
+		for i := range vectors {
+			key := vectorHash(vectors[i], lintDuplicateBucket)
+			for _, j := range buckets[key] {
+				if meanDifference(vectors[i], vectors[j]) < lintDuplicateDistance {
+					duplicates = append(duplicates, fingerprints[i].Timestamp)
+					break
+				}
+			}
+			buckets[key] = append(buckets[key], i)
+		}
+		if len(duplicates) > 0 {
+			report.add(LintIssue{Severity: "warning", Check: "duplicates", Location: location, Timestamps: duplicates,
+				Message: fmt.Sprintf("%d fingerprints are near-duplicates of another", len(duplicates))})
+		}
+
+		if outliers := findOutliers(fingerprints, vectors); len(outliers) > 0 {
+			report.add(LintIssue{Severity: "warning", Check: "outliers", Location: location, Timestamps: outliers,
+				Message: fmt.Sprintf("%d fingerprints are far from the others", len(outliers))})
+		}
+	}
+
+	if len(byLocation) > 1 && float64(len(byLocation[largest])) > lintImbalanceRatio*float64(len(byLocation[smallest])) {
+		report.add(LintIssue{Severity: "warning", Check: "imbalance",
+			Message: fmt.Sprintf("%s has %d fingerprints but %s has only %d", largest, len(byLocation[largest]), smallest, len(byLocation[smallest]))})
+	}
+
+	if len(recent) > 0 {
+		seen := make(map[string]bool)
+		for _, data := range recent {
+			for sensor := range fingerprintVector(data) {
+				seen[sensor] = true
+			}
+		}
+		for _, location := range locations {
+			counts := make(map[string]int)
+			for _, data := range byLocation[location] {
+				for sensor := range fingerprintVector(data) {
+					counts[sensor]++
+				}
+			}
+			var vanished []string
+			for sensor, count := range counts {
+				if !seen[sensor] && float64(count)/float64(len(byLocation[location])) >= lintVanishedDetectionRate {
+					vanished = append(vanished, sensor)
+				}
+			}
+			if len(vanished) > 0 {
+				sort.Strings(vanished)
+				report.add(LintIssue{Severity: "warning", Check: "vanished", Location: location,
+					Message: fmt.Sprintf("%d beacons are no longer seen: %v", len(vanished), vanished)})
+			}
+		}
+	}
+	return
+}
+
+func numSensors(s SensorData) (n int) {
+	for sensorType := range s.Sensors {
+		n += len(s.Sensors[sensorType])
+	}
+	return
+}
+
+// fingerprintVector flattens the numeric sensors of a fingerprint into "type-name" -> value
+func fingerprintVector(s SensorData) (v map[string]float64) {
+	v = make(map[string]float64)
+	for sensorType := range s.Sensors {
+		for name, value := range s.Sensors[sensorType] {
+			if f, ok := value.(float64); ok {
+				v[fmt.Sprintf("%s-%s", sensorType, name)] = f
+			}
+		}
+	}
+	return
+}
+
+// vectorHash hashes the sensors of a fingerprint with their values rounded to
+// the bucket, so that near-duplicates usually have the same hash
+func vectorHash(v map[string]float64, bucket float64) uint64 {
+	sensors := make([]string, 0, len(v))
+	for sensor, value := range v {
+		sensors = append(sensors, fmt.Sprintf("%s=%v", sensor, math.Round(value/bucket)))
+	}
+	sort.Strings(sensors)
+	h := fnv.New64a()
+	for _, sensor := range sensors {
+		h.Write([]byte(sensor))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// meanDifference is the mean absolute difference of two fingerprints, which
+// is infinite if they do not have the same sensors
+func meanDifference(a, b map[string]float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return math.Inf(1)
+	}
+	total := 0.0
+	for sensor, va := range a {
+		vb, ok := b[sensor]
+		if !ok {
+			return math.Inf(1)
+		}
+		total += math.Abs(va - vb)
+	}
+	return total / float64(len(a))
+}
+
+// findOutliers returns the fingerprints whose distance to the centroid of the
+// location is more than lintOutlierDeviations standard deviations from the
+// mean distance. Missing sensors count as zero, as they do for the machine learning.
+func findOutliers(fingerprints []SensorData, vectors []map[string]float64) (outliers []int64) {
+	if len(vectors) < 3 {
+		return
+	}
+	centroid := make(map[string]float64)
+	for _, v := range vectors {
+		for sensor, value := range v {
+			centroid[sensor] += value / float64(len(vectors))
+		}
+	}
+	distances := make([]float64, len(vectors))
+	mean := 0.0
+	for i, v := range vectors {
+		total := 0.0
+		for sensor, c := range centroid {
+			total += math.Pow(v[sensor]-c, 2)
+		}
+		distances[i] = math.Sqrt(total)
+		mean += distances[i] / float64(len(vectors))
+	}
+	sd := 0.0
+	for _, d := range distances {
+		sd += math.Pow(d-mean, 2)
+	}
+	sd = math.Sqrt(sd / float64(len(distances)-1))
+	for i, d := range distances {
+		if sd > 0 && d-mean > lintOutlierDeviations*sd {
+			outliers = append(outliers, fingerprints[i].Timestamp)
+		}
+	}
+	return
+}
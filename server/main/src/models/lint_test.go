@@ -0,0 +1,63 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	datas := []SensorData{}
+	for i := 0; i < 20; i++ {
+		datas = append(datas, SensorData{
+			Timestamp: int64(i),
+			Location:  "kitchen",
+			Sensors: map[string]map[string]interface{}{
+				"wifi": {"ap1": float64(-50 - i%5), "ap2": float64(-70 + i%3)},
+			},
+		})
+	}
+	// the same fingerprint twice
+	datas = append(datas, SensorData{Timestamp: 20, Location: "kitchen", Sensors: map[string]map[string]interface{}{
+		"wifi": {"ap1": -50.0, "ap2": -70.0},
+	}})
+	// a fingerprint taken somewhere else
+	datas = append(datas, SensorData{Timestamp: 21, Location: "kitchen", Sensors: map[string]map[string]interface{}{
+		"wifi": {"ap3": -40.0},
+	}})
+	for i := 0; i < 3; i++ {
+		datas = append(datas, SensorData{Timestamp: int64(100 + i), Location: "bedroom", Sensors: map[string]map[string]interface{}{
+			"wifi": {"ap1": float64(-80 - i), "ap2": -40.0},
+		}})
+	}
+	datas = append(datas, SensorData{Timestamp: 200, Location: "garage", Sensors: map[string]map[string]interface{}{
+		"wifi": {"ap1": -90.0, "ap2": -90.0},
+	}})
+	recent := []SensorData{{Sensors: map[string]map[string]interface{}{"wifi": {"ap1": -60.0}}}}
+
+	report := Lint(datas, recent)
+	assert.True(t, report.HasErrors())
+	checks := make(map[string]LintIssue)
+	for _, issue := range report.Issues {
+		checks[fmt.Sprintf("%s-%s", issue.Check, issue.Location)] = issue
+	}
+	assert.Equal(t, "error", checks["samples-garage"].Severity)
+	assert.Equal(t, "warning", checks["samples-bedroom"].Severity)
+	assert.Equal(t, []int64{21}, checks["sensors-kitchen"].Timestamps)
+	assert.Contains(t, checks["duplicates-kitchen"].Timestamps, int64(20))
+	assert.Equal(t, []int64{21}, checks["outliers-kitchen"].Timestamps)
+	assert.Equal(t, "kitchen has 22 fingerprints but garage has only 1", checks["imbalance-"].Message)
+	assert.Equal(t, "1 beacons are no longer seen: [wifi-ap2]", checks["vanished-kitchen"].Message)
+	assert.Equal(t, 1, report.Errors)
+
+	report = Lint([]SensorData{}, nil)
+	assert.True(t, report.HasErrors())
+}
+
+func TestVectorHash(t *testing.T) {
+	a := map[string]float64{"wifi-ap1": -50, "wifi-ap2": -70}
+	assert.Equal(t, vectorHash(a, 1), vectorHash(map[string]float64{"wifi-ap2": -70.2, "wifi-ap1": -50}, 1))
+	assert.NotEqual(t, vectorHash(a, 1), vectorHash(map[string]float64{"wifi-ap1": -52, "wifi-ap2": -70}, 1))
+	assert.NotEqual(t, vectorHash(a, 1), vectorHash(map[string]float64{"wifi-ap1": -50}, 1))
+}
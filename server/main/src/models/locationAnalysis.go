@@ -5,6 +5,14 @@ type LocationAnalysis struct {
 	LocationNames map[string]string     `json:"location_names"`
 	Predictions   []AlgorithmPrediction `json:"predictions"`
 	Guesses       []LocationPrediction  `json:"guesses,omitempty"`
+	// SmoothedGuesses are the guesses after temporal smoothing, if enabled
+	SmoothedGuesses []LocationPrediction `json:"smoothed_guesses,omitempty"`
+	// Position is estimated from the guesses, if the locations have coordinates
+	Position *Position `json:"position,omitempty"`
+	// Hierarchy is the best guess at each level of the location hierarchy
+	Hierarchy []LevelGuess `json:"hierarchy,omitempty"`
+	// Explanation tells why the locations were guessed, when asked for
+	Explanation *Explanation `json:"explanation,omitempty"`
 }
 
 type AlgorithmPrediction struct {
@@ -17,3 +25,10 @@ type LocationPrediction struct {
 	Location    string  `json:"location,omitempty"`
 	Probability float64 `json:"probability,omitempty"`
 }
+
+// DevicePrediction is a stored prediction for a device at a given time
+type DevicePrediction struct {
+	Timestamp int64                `json:"time"`
+	Device    string               `json:"device_id"`
+	Guesses   []LocationPrediction `json:"guesses"`
+}
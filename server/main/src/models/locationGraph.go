@@ -0,0 +1,102 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// LocationGraph describes which locations of a family connect to each other
+type LocationGraph struct {
+	Locations []string       `json:"locations"`
+	Edges     []LocationEdge `json:"edges"`
+	// Penalty multiplies the probability of a guess that could not have
+	// been reached in time, where 0 removes the guess entirely
+	Penalty float64 `json:"penalty"`
+}
+
+// LocationEdge connects two locations in both directions
+type LocationEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// MinimumSeconds is the minimum time needed to move between the locations
+	MinimumSeconds float64 `json:"min_seconds"`
+}
+
+// Validate will validate that the graph is okay
+func (g LocationGraph) Validate() (err error) {
+	if g.Penalty < 0 || g.Penalty > 1 {
+		return errors.New("penalty must be between 0 and 1")
+	}
+	locations := make(map[string]struct{})
+	for _, loc := range g.Locations {
+		locations[loc] = struct{}{}
+	}
+	for _, edge := range g.Edges {
+		if _, ok := locations[edge.From]; !ok {
+			return fmt.Errorf("edge from unknown location '%s'", edge.From)
+		}
+		if _, ok := locations[edge.To]; !ok {
+			return fmt.Errorf("edge to unknown location '%s'", edge.To)
+		}
+		if edge.MinimumSeconds < 0 {
+			return fmt.Errorf("edge from '%s' to '%s' has negative transit time", edge.From, edge.To)
+		}
+	}
+	return
+}
+
+// HasLocation returns whether the location is part of the graph
+func (g LocationGraph) HasLocation(location string) bool {
+	for _, loc := range g.Locations {
+		if loc == location {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitTime returns the shortest time, in seconds, to move between two
+// locations and whether there is any path between them at all.
+func (g LocationGraph) TransitTime(from, to string) (seconds float64, ok bool) {
+	if from == to {
+		return 0, g.HasLocation(from)
+	}
+	neighbors := make(map[string]map[string]float64)
+	for _, edge := range g.Edges {
+		for _, pair := range [][2]string{{edge.From, edge.To}, {edge.To, edge.From}} {
+			if _, exists := neighbors[pair[0]]; !exists {
+				neighbors[pair[0]] = make(map[string]float64)
+			}
+			if current, exists := neighbors[pair[0]][pair[1]]; !exists || edge.MinimumSeconds < current {
+				neighbors[pair[0]][pair[1]] = edge.MinimumSeconds
+			}
+		}
+	}
+
+	// Dijkstra, the graphs are small enough to scan for the closest location
+	distances := map[string]float64{from: 0}
+	visited := make(map[string]bool)
+	for {
+		closest := ""
+		closestDistance := math.Inf(1)
+		for loc, distance := range distances {
+			if !visited[loc] && distance < closestDistance {
+				closest = loc
+				closestDistance = distance
+			}
+		}
+		if closest == "" {
+			return 0, false
+		}
+		if closest == to {
+			return closestDistance, true
+		}
+		visited[closest] = true
+		for next, edgeSeconds := range neighbors[closest] {
+			if current, exists := distances[next]; !exists || closestDistance+edgeSeconds < current {
+				distances[next] = closestDistance + edgeSeconds
+			}
+		}
+	}
+}
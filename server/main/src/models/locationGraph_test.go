@@ -0,0 +1,38 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationGraph(t *testing.T) {
+	g := LocationGraph{
+		Locations: []string{"kitchen", "hallway", "bedroom", "garage", "attic"},
+		Edges: []LocationEdge{
+			{From: "kitchen", To: "hallway", MinimumSeconds: 3},
+			{From: "hallway", To: "bedroom", MinimumSeconds: 4},
+			{From: "kitchen", To: "garage", MinimumSeconds: 20},
+			{From: "garage", To: "bedroom", MinimumSeconds: 1},
+		},
+	}
+	assert.Nil(t, g.Validate())
+
+	seconds, ok := g.TransitTime("kitchen", "bedroom")
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, seconds)
+
+	seconds, ok = g.TransitTime("bedroom", "kitchen")
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, seconds)
+
+	seconds, ok = g.TransitTime("kitchen", "garage")
+	assert.True(t, ok)
+	assert.Equal(t, 8.0, seconds)
+
+	_, ok = g.TransitTime("kitchen", "attic")
+	assert.False(t, ok)
+
+	g.Edges = append(g.Edges, LocationEdge{From: "kitchen", To: "basement"})
+	assert.NotNil(t, g.Validate())
+}
@@ -2,10 +2,19 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"strings"
 	"time"
 )
 
+// sensorTypeName matches the sensor types that can be stored, as each is a
+// column of the database
+var sensorTypeName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// reservedSensorTypes are the columns of the database that are not sensor types
+var reservedSensorTypes = map[string]bool{"timestamp": true, "deviceid": true, "locationid": true, "status": true}
+
 // SensorData is the typical data structure for storing sensor data.
 type SensorData struct {
 	// Timestamp is the unique identifier, the time in milliseconds
@@ -47,10 +56,36 @@ func (d *SensorData) Validate() (err error) {
 	numFingerprints := 0
 	for sensorType := range d.Sensors {
 		numFingerprints += len(d.Sensors[sensorType])
+		if !sensorTypeName.MatchString(sensorType) || reservedSensorTypes[sensorType] {
+			err = fmt.Errorf("sensor type '%s' must be lowercase letters, digits and underscores", sensorType)
+			return
+		}
 	}
 	if numFingerprints == 0 {
 		err = errors.New("sensor data cannot be empty")
 	}
+	if errValues := d.validateValues(); errValues != nil {
+		err = errValues
+	}
+	return
+}
+
+// validateValues checks the type of each sensor value, and keeps numbers as
+// float64 and categories as lowercase strings
+func (d *SensorData) validateValues() (err error) {
+	// the sensors are copied, as they may be read elsewhere
+	sensors := make(map[string]map[string]interface{})
+	for sensorType := range d.Sensors {
+		sensors[sensorType] = make(map[string]interface{})
+		for name, value := range d.Sensors[sensorType] {
+			v, errValue := ParseSensorValue(sensorType, value)
+			if errValue != nil {
+				return fmt.Errorf("%s sensor '%s': %s", sensorType, name, errValue.Error())
+			}
+			sensors[sensorType][name] = v.Value()
+		}
+	}
+	d.Sensors = sensors
 	return
 }
 
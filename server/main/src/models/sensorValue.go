@@ -0,0 +1,134 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// SensorValueKind is what the value of a sensor measures, which decides how
+// it is encoded for the machine learning
+type SensorValueKind int
+
+const (
+	// RSSIValue is the signal strength of a sensor type in RSSISensorTypes
+	RSSIValue SensorValueKind = iota
+	// NumericValue is any other reading, like a temperature, a magnetic field
+	// or a barometric pressure
+	NumericValue
+	// CategoricalValue is a string or a boolean, like whether a door is open
+	CategoricalValue
+)
+
+func (k SensorValueKind) String() string {
+	switch k {
+	case RSSIValue:
+		return "rssi"
+	case NumericValue:
+		return "numeric"
+	default:
+		return "categorical"
+	}
+}
+
+// SensorValue is the typed value of a sensor
+type SensorValue struct {
+	Kind SensorValueKind
+	// Number is the value of RSSI and numeric values
+	Number float64
+	// Category is the value of categorical values
+	Category string
+}
+
+// ParseSensorValue types the value of a sensor. Numbers are signal strengths
+// for the RSSI sensor types and numeric readings for the others, while
+// strings and booleans are categories.
+func ParseSensorValue(sensorType string, value interface{}) (v SensorValue, err error) {
+	switch x := value.(type) {
+	case float64:
+		v.Number = x
+	case float32:
+		v.Number = float64(x)
+	case int:
+		v.Number = float64(x)
+	case int64:
+		v.Number = float64(x)
+	case json.Number:
+		if v.Number, err = x.Float64(); err != nil {
+			return
+		}
+	case bool:
+		v.Kind = CategoricalValue
+		v.Category = strconv.FormatBool(x)
+	case string:
+		v.Kind = CategoricalValue
+		v.Category = strings.ToLower(strings.TrimSpace(x))
+		if v.Category == "" {
+			err = errors.New("category cannot be empty")
+			return
+		}
+		// the categories are written in the CSV that the AI learns from
+		if strings.ContainsAny(v.Category, ",\"\r\n") {
+			err = fmt.Errorf("category '%s' cannot have commas, quotes or line breaks", v.Category)
+			return
+		}
+	default:
+		err = fmt.Errorf("value %v must be a number, a string or a boolean", value)
+		return
+	}
+	if v.Kind == CategoricalValue {
+		if RSSISensorTypes[sensorType] {
+			err = fmt.Errorf("signal strength '%s' must be a number", v.Category)
+		}
+		return
+	}
+	if math.IsNaN(v.Number) || math.IsInf(v.Number, 0) {
+		err = errors.New("value must be a finite number")
+		return
+	}
+	v.Kind = NumericValue
+	if RSSISensorTypes[sensorType] {
+		v.Kind = RSSIValue
+	}
+	return
+}
+
+// Value returns the value the way it is kept in sensor data
+func (v SensorValue) Value() interface{} {
+	if v.Kind == CategoricalValue {
+		return v.Category
+	}
+	return v.Number
+}
+
+// Feature returns the name and value of the sensor for the machine learning.
+// A category becomes its own sensor, "name=category", which is 1 when seen
+// and missing (so 0) otherwise.
+func (v SensorValue) Feature(name string) (feature string, value float64) {
+	if v.Kind == CategoricalValue {
+		return name + "=" + v.Category, 1
+	}
+	return name, v.Number
+}
+
+// Encoded returns a copy of the sensor data where every value is a number,
+// the way the machine learning uses it. Values that are not valid are dropped.
+func (d SensorData) Encoded() SensorData {
+	sensors := make(map[string]map[string]interface{})
+	for sensorType := range d.Sensors {
+		sensors[sensorType] = make(map[string]interface{})
+		for name, value := range d.Sensors[sensorType] {
+			v, err := ParseSensorValue(sensorType, value)
+			if err != nil {
+				continue
+			}
+			feature, number := v.Feature(name)
+			sensors[sensorType][feature] = number
+		}
+	}
+	d.Sensors = sensors
+	return d
+}
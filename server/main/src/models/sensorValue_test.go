@@ -0,0 +1,78 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSensorValue(t *testing.T) {
+	v, err := ParseSensorValue("wifi", -45.0)
+	assert.Nil(t, err)
+	assert.Equal(t, SensorValue{Kind: RSSIValue, Number: -45}, v)
+
+	v, err = ParseSensorValue("temperature", 21)
+	assert.Nil(t, err)
+	assert.Equal(t, SensorValue{Kind: NumericValue, Number: 21}, v)
+
+	v, err = ParseSensorValue("door", " Open")
+	assert.Nil(t, err)
+	assert.Equal(t, SensorValue{Kind: CategoricalValue, Category: "open"}, v)
+	feature, value := v.Feature("front")
+	assert.Equal(t, "front=open", feature)
+	assert.Equal(t, 1.0, value)
+
+	for _, category := range []string{"open,closed", "say \"hi\"", "open\nclosed"} {
+		_, err = ParseSensorValue("door", category)
+		assert.NotNil(t, err, category)
+	}
+
+	v, err = ParseSensorValue("motion", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "true", v.Value())
+
+	_, err = ParseSensorValue("wifi", "strong")
+	assert.NotNil(t, err)
+	_, err = ParseSensorValue("temperature", math.NaN())
+	assert.NotNil(t, err)
+	_, err = ParseSensorValue("magnetometer", []interface{}{1.0, 2.0})
+	assert.NotNil(t, err)
+	_, err = ParseSensorValue("door", "")
+	assert.NotNil(t, err)
+}
+
+func TestValidateSensorValues(t *testing.T) {
+	s := SensorData{
+		Family: "family",
+		Device: "device",
+		Sensors: map[string]map[string]interface{}{
+			"wifi":     {"a": -45.0},
+			"pressure": {"b": 1013},
+			"door":     {"front": "OPEN"},
+		},
+	}
+	original := s.Sensors
+	assert.Nil(t, s.Validate())
+	assert.Equal(t, 1013.0, s.Sensors["pressure"]["b"])
+	assert.Equal(t, "open", s.Sensors["door"]["front"])
+	// the sensors of the original are not changed
+	assert.Equal(t, 1013, original["pressure"]["b"])
+
+	encoded := s.Encoded()
+	assert.Equal(t, map[string]map[string]interface{}{
+		"wifi":     {"a": -45.0},
+		"pressure": {"b": 1013.0},
+		"door":     {"front=open": 1.0},
+	}, encoded.Sensors)
+	assert.Equal(t, encoded.Sensors, encoded.Encoded().Sensors)
+
+	s.Sensors["wifi"]["a"] = "strong"
+	assert.NotNil(t, s.Validate())
+
+	// sensor types are columns of the database
+	for _, sensorType := range []string{"Wifi", "wifi-2", "status", "a b"} {
+		s = SensorData{Family: "family", Device: "device", Sensors: map[string]map[string]interface{}{sensorType: {"a": 1.0}}}
+		assert.NotNil(t, s.Validate(), sensorType)
+	}
+}
@@ -0,0 +1,76 @@
+package models
+
+import "time"
+
+// ShadowLogSize is the number of the latest predictions of a candidate model that are kept
+const ShadowLogSize = 100
+
+// CandidateSettings are how a candidate model differs from the live model of
+// a family. The settings that are not set are those of the live model.
+type CandidateSettings struct {
+	// Algorithms are the AI algorithms whose predictions are combined
+	Algorithms       []string         `json:"algorithms,omitempty"`
+	FeatureSettings  *FeatureSettings `json:"feature_settings,omitempty"`
+	EnsembleStrategy string           `json:"ensemble_strategy,omitempty"`
+}
+
+// ShadowPrediction is a fingerprint classified by both the live and the candidate model
+type ShadowPrediction struct {
+	Timestamp int64  `json:"timestamp"`
+	Device    string `json:"device"`
+	// Location is the true location, for learning data
+	Location  string `json:"location,omitempty"`
+	Live      string `json:"live"`
+	Candidate string `json:"candidate"`
+}
+
+// ShadowReport compares a candidate model with the live model of a family on live data
+type ShadowReport struct {
+	ID string `json:"id"`
+	// Model is the name under which the AI learned the candidate
+	Model    string            `json:"model"`
+	Settings CandidateSettings `json:"settings"`
+	// Status is "calibrating", "shadowing", "failed", "stopped" or "promoted"
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Created time.Time `json:"created"`
+	// Promoted is nil until the candidate is promoted
+	Promoted *time.Time `json:"promoted,omitempty"`
+
+	// Compared is the number of fingerprints classified by both models
+	Compared  int     `json:"compared"`
+	Agreed    int     `json:"agreed"`
+	Agreement float64 `json:"agreement"`
+	// Labeled is the number of compared fingerprints with a true location
+	Labeled           int     `json:"labeled"`
+	LiveCorrect       int     `json:"live_correct"`
+	CandidateCorrect  int     `json:"candidate_correct"`
+	LiveAccuracy      float64 `json:"live_accuracy"`
+	CandidateAccuracy float64 `json:"candidate_accuracy"`
+	// Recent are the latest predictions, newest last
+	Recent []ShadowPrediction `json:"recent"`
+}
+
+// Add compares the live and the candidate prediction of a fingerprint
+func (r *ShadowReport) Add(p ShadowPrediction) {
+	r.Compared++
+	if p.Live == p.Candidate {
+		r.Agreed++
+	}
+	r.Agreement = float64(r.Agreed) / float64(r.Compared)
+	if p.Location != "" {
+		r.Labeled++
+		if p.Live == p.Location {
+			r.LiveCorrect++
+		}
+		if p.Candidate == p.Location {
+			r.CandidateCorrect++
+		}
+		r.LiveAccuracy = float64(r.LiveCorrect) / float64(r.Labeled)
+		r.CandidateAccuracy = float64(r.CandidateCorrect) / float64(r.Labeled)
+	}
+	r.Recent = append(r.Recent, p)
+	if len(r.Recent) > ShadowLogSize {
+		r.Recent = r.Recent[len(r.Recent)-ShadowLogSize:]
+	}
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowReport(t *testing.T) {
+	var r ShadowReport
+	r.Add(ShadowPrediction{Timestamp: 1, Live: "kitchen", Candidate: "kitchen"})
+	r.Add(ShadowPrediction{Timestamp: 2, Live: "kitchen", Candidate: "bedroom"})
+	assert.Equal(t, 2, r.Compared)
+	assert.Equal(t, 0.5, r.Agreement)
+	assert.Equal(t, 0, r.Labeled)
+
+	r.Add(ShadowPrediction{Timestamp: 3, Location: "bedroom", Live: "kitchen", Candidate: "bedroom"})
+	r.Add(ShadowPrediction{Timestamp: 4, Location: "kitchen", Live: "kitchen", Candidate: "kitchen"})
+	assert.Equal(t, 4, r.Compared)
+	assert.Equal(t, 0.5, r.Agreement)
+	assert.Equal(t, 2, r.Labeled)
+	assert.Equal(t, 0.5, r.LiveAccuracy)
+	assert.Equal(t, 1.0, r.CandidateAccuracy)
+
+	for i := 0; i < ShadowLogSize; i++ {
+		r.Add(ShadowPrediction{Timestamp: int64(5 + i), Live: "kitchen", Candidate: "kitchen"})
+	}
+	assert.Equal(t, ShadowLogSize, len(r.Recent))
+	assert.Equal(t, int64(5), r.Recent[0].Timestamp)
+	assert.Equal(t, int64(4+ShadowLogSize), r.Recent[len(r.Recent)-1].Timestamp)
+}
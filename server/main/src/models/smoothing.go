@@ -0,0 +1,13 @@
+package models
+
+// SmoothingSettings are the settings for temporal smoothing of device locations
+type SmoothingSettings struct {
+	// Enabled turns on smoothing for tracking
+	Enabled bool `json:"enabled"`
+	// StayProbability is the probability that a device stays in the
+	// same location between two consecutive fingerprints
+	StayProbability float64 `json:"stay_probability"`
+	// ResetSeconds is the time without fingerprints after which
+	// the smoothing of a device starts over
+	ResetSeconds int64 `json:"reset_seconds"`
+}
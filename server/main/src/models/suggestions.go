@@ -0,0 +1,161 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// SuggestionBatch is the number of more scans of a location whose gain in
+// accuracy ranks the suggestions
+const SuggestionBatch = 50
+
+// SuggestionTargetError is the error of a location that the suggested scans should reach
+const SuggestionTargetError = 0.1
+
+// Suggestion is a location where collecting more learning data should help
+type Suggestion struct {
+	Location string `json:"location"`
+	// Samples is the number of learning fingerprints of the location
+	Samples int `json:"samples"`
+	// Accuracy is the accuracy of the algorithms for the location during cross validation
+	Accuracy float64 `json:"accuracy"`
+	// ConfusedWith is the location it was guessed to be the most, when wrong
+	ConfusedWith string `json:"confused_with,omitempty"`
+	// Tracked is the number of recent tracking fingerprints guessed to be at the location
+	Tracked int `json:"tracked"`
+	// Entropy is the mean entropy of their guesses, from 0 for certain to 1
+	// for all locations equally likely
+	Entropy float64 `json:"entropy"`
+	// ExpectedGain is the expected gain in accuracy of the family from
+	// SuggestionBatch more scans of the location
+	ExpectedGain float64 `json:"expected_gain"`
+	// Scans is the number of more scans expected to reach the target error
+	Scans   int    `json:"scans"`
+	Message string `json:"message"`
+}
+
+// SuggestLearning ranks the locations by the expected gain in accuracy from
+// more learning data. The error of a location is its cross validation error,
+// or the entropy of its recent tracking guesses if that is higher, and is
+// expected to fall as 1/n with the number n of learning fingerprints. Each
+// location counts for the family by its share of the tracking fingerprints.
+func SuggestLearning(analysis map[string]ConfusionMatrix, counts map[string]int, tracking []DevicePrediction) (suggestions []Suggestion) {
+	// accuracy of each location, averaged over the algorithms
+	correct := make(map[string]float64)
+	totals := make(map[string]float64)
+	confused := make(map[string]map[string]int)
+	for _, m := range analysis {
+		for location := range m {
+			total := 0
+			for guessed, count := range m[location] {
+				total += count
+				if guessed != location && count > 0 {
+					if _, ok := confused[location]; !ok {
+						confused[location] = make(map[string]int)
+					}
+					confused[location][guessed] += count
+				}
+			}
+			if total == 0 {
+				continue
+			}
+			correct[location] += float64(m[location][location]) / float64(total)
+			totals[location]++
+		}
+	}
+
+	// entropy of the recent tracking guesses of each location
+	tracked := make(map[string]int)
+	entropies := make(map[string]float64)
+	numTracked := 0
+	for _, p := range tracking {
+		if len(p.Guesses) == 0 || p.Guesses[0].Location == "?" {
+			continue
+		}
+		tracked[p.Guesses[0].Location]++
+		entropies[p.Guesses[0].Location] += guessEntropy(p.Guesses)
+		numTracked++
+	}
+
+	locations := make(map[string]struct{})
+	for location := range totals {
+		locations[location] = struct{}{}
+	}
+	for location := range counts {
+		if location != "" {
+			locations[location] = struct{}{}
+		}
+	}
+
+	suggestions = []Suggestion{}
+	for location := range locations {
+		s := Suggestion{
+			Location: location,
+			Samples:  counts[location],
+			Tracked:  tracked[location],
+		}
+		errorRate := 1.0
+		if totals[location] > 0 {
+			s.Accuracy = correct[location] / totals[location]
+			errorRate = 1 - s.Accuracy
+		}
+		if s.Tracked > 0 {
+			s.Entropy = entropies[location] / float64(s.Tracked)
+			errorRate = math.Max(errorRate, s.Entropy)
+		}
+		if errorRate <= SuggestionTargetError {
+			continue
+		}
+		mostConfused := 0
+		for guessed, count := range confused[location] {
+			if count > mostConfused || (count == mostConfused && guessed < s.ConfusedWith) {
+				mostConfused = count
+				s.ConfusedWith = guessed
+			}
+		}
+
+		weight := 1 / float64(len(locations))
+		if numTracked > 0 {
+			weight = float64(s.Tracked) / float64(numTracked)
+		}
+		n := math.Max(float64(s.Samples), 1)
+		s.ExpectedGain = weight * errorRate * SuggestionBatch / (n + SuggestionBatch)
+		// error*n/(n+scans) = target, rounded up to tens of scans
+		s.Scans = int(math.Ceil(math.Round(n*(errorRate/SuggestionTargetError-1))/10)) * 10
+
+		s.Message = fmt.Sprintf("collect ~%d more scans in %s", s.Scans, location)
+		if s.ConfusedWith != "" {
+			s.Message += fmt.Sprintf(", which is confused with %s", s.ConfusedWith)
+		}
+		suggestions = append(suggestions, s)
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].ExpectedGain != suggestions[j].ExpectedGain {
+			return suggestions[i].ExpectedGain > suggestions[j].ExpectedGain
+		}
+		return suggestions[i].Location < suggestions[j].Location
+	})
+	return
+}
+
+// guessEntropy is the entropy of the probabilities of the guesses, divided
+// by its largest possible value so that it is between 0 and 1
+func guessEntropy(guesses []LocationPrediction) (entropy float64) {
+	if len(guesses) < 2 {
+		return
+	}
+	total := 0.0
+	for _, guess := range guesses {
+		total += guess.Probability
+	}
+	if total <= 0 {
+		return
+	}
+	for _, guess := range guesses {
+		if p := guess.Probability / total; p > 0 {
+			entropy -= p * math.Log(p)
+		}
+	}
+	return entropy / math.Log(float64(len(guesses)))
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestLearning(t *testing.T) {
+	analysis := map[string]ConfusionMatrix{
+		"knn": {
+			"kitchen":     {"kitchen": 6, "living room": 4},
+			"living room": {"living room": 8, "kitchen": 2},
+			"office":      {"office": 10},
+		},
+		"svm": {
+			"kitchen":     {"kitchen": 8, "living room": 2},
+			"living room": {"living room": 9, "kitchen": 1},
+			"office":      {"office": 10},
+		},
+	}
+	counts := map[string]int{"kitchen": 30, "living room": 30, "office": 30, "garage": 5, "": 1000}
+
+	suggestions := SuggestLearning(analysis, counts, nil)
+	// the office is accurate enough, the garage was never tested
+	assert.Equal(t, 3, len(suggestions))
+	assert.Equal(t, "garage", suggestions[0].Location)
+	assert.Equal(t, "kitchen", suggestions[1].Location)
+	assert.InDelta(t, 0.7, suggestions[1].Accuracy, 1e-9)
+	assert.Equal(t, "living room", suggestions[1].ConfusedWith)
+	// 0.3*30/(30+scans) = 0.1
+	assert.Equal(t, 60, suggestions[1].Scans)
+	assert.Equal(t, "collect ~60 more scans in kitchen, which is confused with living room", suggestions[1].Message)
+	assert.Equal(t, "living room", suggestions[2].Location)
+
+	// the office is uncertain and often visited when tracking
+	tracking := []DevicePrediction{}
+	for i := 0; i < 10; i++ {
+		tracking = append(tracking, DevicePrediction{Guesses: []LocationPrediction{{Location: "office", Probability: 0.5}, {Location: "kitchen", Probability: 0.5}}})
+	}
+	tracking = append(tracking, DevicePrediction{Guesses: []LocationPrediction{{Location: "?", Probability: 1}}})
+	suggestions = SuggestLearning(analysis, counts, tracking)
+	assert.Equal(t, "office", suggestions[0].Location)
+	assert.Equal(t, 10, suggestions[0].Tracked)
+	assert.InDelta(t, 1, suggestions[0].Entropy, 1e-9)
+}
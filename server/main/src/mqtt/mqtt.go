@@ -209,6 +209,19 @@ func Publish(family, device, message string) (err error) {
 	return
 }
 
+// PublishEvent publishes an event of the family, like "drift", to FAMILY/event/EVENT
+func PublishEvent(family, event, message string) (err error) {
+	if !IsSetup {
+		return errors.New("mqtt not setup")
+	}
+	pubTopic := strings.Join([]string{family, "/event/", event}, "")
+
+	if token := adminClient.Publish(pubTopic, 1, false, message); token.Wait() && token.Error() != nil {
+		err = fmt.Errorf("Failed to send message")
+	}
+	return
+}
+
 func messageReceived(client MQTT.Client, msg MQTT.Message) {
 	jsonFingerprint, route, err := mqttBuildFingerprint(msg.Topic(), msg.Payload())
 	if err != nil {
@@ -223,11 +236,15 @@ func messageReceived(client MQTT.Client, msg MQTT.Message) {
 		logger.Log.Error(err)
 		return
 	}
-	_, err = sendOutData(d)
+	go api.UpdateBeacons(d)
+	analysis, err := sendOutData(d)
 	if err != nil {
 		logger.Log.Error(err)
 		return
 	}
+	if route == "track" {
+		api.ObserveDrift(d, analysis, db)
+	}
 }
 
 func sendOutData(p models.SensorData) (analysis models.LocationAnalysis, err error) {
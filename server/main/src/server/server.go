@@ -1,6 +1,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -35,6 +36,9 @@ func Run(debugMode bool) (err error) {
 	}
 	defer db.Close()
 
+	// check the calibration policies
+	api.StartScheduler("default")
+
 	if UseMQTT {
 		// setup MQTT
 		err = mqtt.Setup(db)
@@ -42,6 +46,16 @@ func Run(debugMode bool) (err error) {
 			logger.Log.Warn(err)
 		}
 		logger.Log.Debug("setup mqtt")
+		// publish the drift alerts
+		api.OnDriftAlert = func(family string, report models.DriftReport) {
+			bReport, errMarshal := json.Marshal(report)
+			if errMarshal != nil {
+				return
+			}
+			if errPublish := mqtt.PublishEvent(family, "drift", string(bReport)); errPublish != nil {
+				logger.Log.Warnf("[%s] problem publishing drift: %s", family, errPublish.Error())
+			}
+		}
 	}
 
 	// setup gin server
@@ -296,12 +310,68 @@ func Run(debugMode bool) (err error) {
 	r.GET("/efficacy", handlerEfficacy)
 	r.OPTIONS("/now", func(c *gin.Context) { c.String(200, "OK") })
 	r.GET("/now", handlerNow)
+	r.OPTIONS("/health", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/health", handlerHealth)
 	r.OPTIONS("/locate", func(c *gin.Context) { c.String(200, "OK") })
 	r.POST("/locate", handlerLocate)
+	r.OPTIONS("/locate/batch", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/locate/batch", handlerLocateBatch)
+	r.OPTIONS("/api/v1/settings/smoothing/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/settings/smoothing/:family", handlerSmoothingSettings)
+	r.POST("/api/v1/settings/smoothing/:family", handlerSmoothingSettings)
+	r.OPTIONS("/api/v1/settings/ensemble/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/settings/ensemble/:family", handlerEnsembleSettings)
+	r.POST("/api/v1/settings/ensemble/:family", handlerEnsembleSettings)
+	r.OPTIONS("/api/v1/settings/features/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/settings/features/:family", handlerFeatureSettings)
+	r.POST("/api/v1/settings/features/:family", handlerFeatureSettings)
+	r.OPTIONS("/api/v1/coordinates/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/coordinates/:family", handlerCoordinates)
+	r.POST("/api/v1/coordinates/:family", handlerCoordinates)
+	r.OPTIONS("/api/v1/offsets/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/offsets/:family", handlerDeviceOffsets)
+	r.DELETE("/api/v1/offsets/:family", handlerDeviceOffsets)
+	r.OPTIONS("/api/v1/offsets/:family/:device", func(c *gin.Context) { c.String(200, "OK") })
+	r.DELETE("/api/v1/offsets/:family/:device", handlerDeviceOffsets)
+	r.OPTIONS("/api/v1/hierarchy/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/hierarchy/:family", handlerLocationHierarchy)
+	r.POST("/api/v1/hierarchy/:family", handlerLocationHierarchy)
+	r.OPTIONS("/api/v1/graph/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/graph/:family", handlerLocationGraph)
+	r.POST("/api/v1/graph/:family", handlerLocationGraph)
+	r.OPTIONS("/api/v1/calibration/:family/status", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/calibration/:family/status", handlerCalibrationStatus)
+	r.OPTIONS("/api/v1/calibration/:family/confusion", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/calibration/:family/confusion", handlerCalibrationConfusion)
+	r.OPTIONS("/api/v1/calibration/:family/lint", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/calibration/:family/lint", handlerCalibrationLint)
+	r.OPTIONS("/api/v1/calibration/:family/suggestions", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/calibration/:family/suggestions", handlerCalibrationSuggestions)
+	r.OPTIONS("/api/v1/calibration/:family/policy", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/calibration/:family/policy", handlerCalibrationPolicy)
+	r.OPTIONS("/api/v1/beacons/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/beacons/:family", handlerBeacons)
+	r.OPTIONS("/api/v1/drift/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/drift/:family", handlerDrift)
+	r.OPTIONS("/api/v1/learning/:family/:device", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/learning/:family/:device", handlerLearningSession)
+	r.DELETE("/api/v1/learning/:family/:device", handlerLearningSession)
+	r.OPTIONS("/api/v1/learning/:family/:device/start", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/learning/:family/:device/start", handlerLearningSession)
+	r.OPTIONS("/api/v1/shadow/:family", func(c *gin.Context) { c.String(200, "OK") })
+	r.GET("/api/v1/shadow/:family", handlerShadow)
+	r.POST("/api/v1/shadow/:family", handlerShadow)
+	r.DELETE("/api/v1/shadow/:family", handlerShadow)
+	r.OPTIONS("/api/v1/shadow/:family/promote", func(c *gin.Context) { c.String(200, "OK") })
+	r.POST("/api/v1/shadow/:family/promote", handlerShadowPromote)
 
 	if debugMode {
 		r.OPTIONS("/calibrate", func(c *gin.Context) { c.String(200, "OK") })
 		r.GET("/calibrate", handlerCalibrate)
+		r.POST("/calibrate", handlerCalibrate)
+		r.OPTIONS("/calibrate/:id", func(c *gin.Context) { c.String(200, "OK") })
+		r.GET("/calibrate/:id", handlerCalibrationJob)
+		r.DELETE("/calibrate/:id", handlerCalibrationJob)
 		r.OPTIONS("/learn", func(c *gin.Context) { c.String(200, "OK") })
 		r.POST("/learn", handlerLearn)
 
@@ -313,6 +383,25 @@ func Run(debugMode bool) (err error) {
 	return
 }
 
+// handlerLocateBatch classifies many sensor data at once, for reprocessing history
+func handlerLocateBatch(c *gin.Context) {
+	results, err := func(c *gin.Context) (results []api.BatchResult, err error) {
+		var datas []models.SensorData
+		if err = c.BindJSON(&datas); err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		store := c.DefaultQuery("store", "0") == "1"
+		results, err = api.LocateBatch(c.Request.Context(), datas, db, store)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("classified %d sensor data", len(results)), "success": true, "results": results})
+	}
+}
+
 func handlerLocate(c *gin.Context) {
 	analysis, err := func(c *gin.Context) (analysis models.LocationAnalysis, err error) {
 
@@ -323,15 +412,23 @@ func handlerLocate(c *gin.Context) {
 			return
 		}
 
-		// add timestamp to sensor data (if missing)
-		if s.Timestamp == 0 {
-			s.Timestamp = time.Now().UTC().UnixNano() / int64(time.Millisecond)
+		// validating adds the timestamp (if missing) and checks the sensor values
+		if err = s.Validate(); err != nil {
+			return
 		}
 
+		// save as learning data for the learning session of the device
+		api.LearnInSession(&s)
+
 		// store sensor data in db
 		go func() {
 			if err := db.StoreSensorData(s); err != nil {
 				logger.Log.Errorf("Failed to store sensor data %s", err.Error())
+				return
+			}
+			api.UpdateBeacons(s)
+			if s.Location != "" {
+				api.CountLearningSample(s.Family)
 			}
 		}()
 
@@ -339,6 +436,14 @@ func handlerLocate(c *gin.Context) {
 		if analysis, err = api.AnalyzeSensorData(s, db); err != nil {
 			return
 		}
+		// compare with the candidate model, if there is one
+		if len(analysis.Guesses) > 0 {
+			api.ObserveShadow(s, analysis.Guesses[0])
+		}
+		// forbid impossible moves between locations
+		if errConstrain := api.ConstrainTransitions(s, &analysis, db); errConstrain != nil {
+			logger.Log.Warnf("[%s] problem constraining transitions: %s", s.Family, errConstrain.Error())
+		}
 		// remove guesses with prob == 0
 		for i, guess := range analysis.Guesses {
 			if guess.Probability == 0 {
@@ -347,6 +452,23 @@ func handlerLocate(c *gin.Context) {
 			}
 		}
 
+		// smooth the location of the device over time
+		if errSmooth := api.SmoothLocation(s, &analysis, db); errSmooth != nil {
+			logger.Log.Warnf("[%s] problem smoothing: %s", s.Family, errSmooth.Error())
+		}
+
+		// compare the environment with the last calibration
+		if errDrift := api.ObserveDrift(s, analysis, db); errDrift != nil {
+			logger.Log.Debugf("[%s] not observing drift: %s", s.Family, errDrift.Error())
+		}
+
+		// explain the guesses
+		if c.DefaultQuery("explain", "false") == "true" {
+			if errExplain := api.ExplainAnalysis(s, &analysis, db); errExplain != nil {
+				logger.Log.Warnf("[%s] problem explaining: %s", s.Family, errExplain.Error())
+			}
+		}
+
 		// store prediction in db
 		go func() {
 			if err := db.AddPrediction(s.Timestamp, analysis.Guesses); err != nil {
@@ -361,15 +483,249 @@ func handlerLocate(c *gin.Context) {
 		logger.Log.Errorf("problem locating: %s", err.Error())
 		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
 	} else {
-		c.JSON(http.StatusOK, gin.H{"guesses": analysis.Guesses, "success": true})
+		response := gin.H{"guesses": analysis.Guesses, "success": true}
+		if analysis.Position != nil {
+			response["position"] = analysis.Position
+		}
+		if len(analysis.Hierarchy) > 0 {
+			response["hierarchy"] = analysis.Hierarchy
+		}
+		if len(analysis.SmoothedGuesses) > 0 {
+			response["smoothed_guesses"] = analysis.SmoothedGuesses
+		}
+		if analysis.Explanation != nil {
+			response["explanation"] = analysis.Explanation
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+func handlerSmoothingSettings(c *gin.Context) {
+	settings, err := func(c *gin.Context) (settings models.SmoothingSettings, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			settings = api.DefaultSmoothingSettings
+			if err = c.BindJSON(&settings); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			if err = api.SetSmoothingSettings(family, settings, d); err != nil {
+				return
+			}
+		}
+		settings = api.GetSmoothingSettings(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got smoothing settings", "success": true, "settings": settings})
+	}
+}
+
+func handlerFeatureSettings(c *gin.Context) {
+	settings, features, err := func(c *gin.Context) (settings models.FeatureSettings, features models.FeatureSet, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			settings = api.DefaultFeatureSettings
+			if err = c.BindJSON(&settings); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			if err = api.SetFeatureSettings(settings, d); err != nil {
+				return
+			}
+		}
+		settings = api.GetFeatureSettings(d)
+		features = api.GetFeatureSet(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got feature settings", "success": true, "settings": settings, "features": features})
+	}
+}
+
+func handlerEnsembleSettings(c *gin.Context) {
+	strategy, err := func(c *gin.Context) (strategy string, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			type EnsembleSettings struct {
+				Strategy string `json:"strategy" binding:"required"`
+			}
+			var settings EnsembleSettings
+			if err = c.BindJSON(&settings); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			if err = api.SetEnsembleStrategy(settings.Strategy, d); err != nil {
+				return
+			}
+		}
+		strategy = api.GetEnsembleStrategy(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got ensemble strategy", "success": true, "strategy": strategy, "strategies": api.EnsembleStrategies})
+	}
+}
+
+func handlerCoordinates(c *gin.Context) {
+	coordinates, err := func(c *gin.Context) (coordinates map[string]models.Coordinates, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			if err = c.BindJSON(&coordinates); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			if err = api.SetLocationCoordinates(family, coordinates, d); err != nil {
+				return
+			}
+		}
+		coordinates = api.GetLocationCoordinates(family, d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("got coordinates of %d locations", len(coordinates)), "success": true, "coordinates": coordinates})
+	}
+}
+
+func handlerDeviceOffsets(c *gin.Context) {
+	offsets, err := func(c *gin.Context) (offsets map[string]models.DeviceOffset, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "DELETE" {
+			device := strings.ToLower(strings.TrimSpace(c.Param("device")))
+			pin := c.DefaultQuery("estimate", "false") != "true"
+			if device != "" {
+				err = api.ResetDeviceOffsets(d, pin, device)
+			} else {
+				err = api.ResetDeviceOffsets(d, pin)
+			}
+			if err != nil {
+				return
+			}
+		}
+		offsets = api.GetDeviceOffsets(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("got offsets for %d devices", len(offsets)), "success": true, "offsets": offsets})
+	}
+}
+
+func handlerLocationGraph(c *gin.Context) {
+	g, err := func(c *gin.Context) (g models.LocationGraph, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			g = models.LocationGraph{Penalty: api.DefaultTransitionPenalty}
+			if err = c.BindJSON(&g); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			err = api.SetLocationGraph(g, d)
+			return
+		}
+		g, err = api.GetLocationGraph(d)
+		if err != nil {
+			err = errors.Wrap(err, "no location graph")
+		}
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got location graph", "success": true, "graph": g})
+	}
+}
+
+func handlerLocationHierarchy(c *gin.Context) {
+	h, err := func(c *gin.Context) (h models.LocationHierarchy, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		if c.Request.Method == "POST" {
+			if err = c.BindJSON(&h); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			err = api.SetLocationHierarchy(h, d)
+			return
+		}
+		h, err = api.GetLocationHierarchy(d)
+		if err != nil {
+			err = errors.Wrap(err, "no location hierarchy")
+		}
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got location hierarchy", "success": true, "hierarchy": h})
 	}
 }
 
+// familyDatabase opens the database of the family given in the route
+func familyDatabase(c *gin.Context) (family string, d *database.Database, err error) {
+	family = strings.ToLower(strings.TrimSpace(c.Param("family")))
+	if family == "" {
+		err = errors.New("invalid family")
+		return
+	}
+	d, err = database.Open(family)
+	return
+}
+
 func handlerEfficacy(c *gin.Context) {
 	type Efficacy struct {
 		AccuracyBreakdown   map[string]float64                       `json:"accuracy_breakdown"`
 		ConfusionMetrics    map[string]map[string]models.BinaryStats `json:"confusion_metrics"`
 		LastCalibrationTime time.Time                                `json:"last_calibration_time"`
+		MinimumProbability  float64                                  `json:"minimum_probability"`
+		EnsembleStrategy    string                                   `json:"ensemble_strategy"`
+		EnsembleAccuracy    map[string]float64                       `json:"ensemble_accuracy"`
+		MeanPositionError   float64                                  `json:"mean_position_error"`
+		FloorAccuracy       float64                                  `json:"floor_accuracy"`
+		Cache               api.CacheStats                           `json:"cache"`
 	}
 
 	efficacy, err := func(c *gin.Context) (efficacy Efficacy, err error) {
@@ -377,6 +733,12 @@ func handlerEfficacy(c *gin.Context) {
 		keyValues["LastCalibrationTime"] = &efficacy.LastCalibrationTime
 		keyValues["AccuracyBreakdown"] = &efficacy.AccuracyBreakdown
 		keyValues["AlgorithmEfficacy"] = &efficacy.ConfusionMetrics
+		keyValues["MinimumProbability"] = &efficacy.MinimumProbability
+		keyValues["EnsembleAccuracy"] = &efficacy.EnsembleAccuracy
+		keyValues["MeanPositionError"] = &efficacy.MeanPositionError
+		keyValues["FloorAccuracy"] = &efficacy.FloorAccuracy
+		efficacy.EnsembleStrategy = api.GetEnsembleStrategy(db)
+		efficacy.Cache = api.GetCacheStats("default")
 		if err := db.GetMany(keyValues); err != nil {
 			err = errors.Wrap(err, "could not get efficacy info")
 		}
@@ -390,12 +752,245 @@ func handlerEfficacy(c *gin.Context) {
 }
 
 func handlerCalibrate(c *gin.Context) {
-	err := api.Calibrate("default", db, true)
-	message := "calibrated data"
+	family := strings.ToLower(strings.TrimSpace(c.DefaultQuery("family", "default")))
+	job, err := api.StartCalibration(family, "manual")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "started calibration", "success": true, "job": job})
+	}
+}
+
+func handlerCalibrationJob(c *gin.Context) {
+	var job models.CalibrationJob
+	var err error
+	if c.Request.Method == "DELETE" {
+		job, err = api.CancelCalibration(c.Param("id"))
+	} else {
+		job, err = api.GetCalibration(c.Param("id"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got calibration " + job.Phase, "success": true, "job": job})
+	}
+}
+
+func handlerCalibrationConfusion(c *gin.Context) {
+	report, err := func(c *gin.Context) (report models.ConfusionReport, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		report, err = api.GetConfusionReport(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got confusion", "success": true, "confusion": report})
+	}
+}
+
+func handlerCalibrationSuggestions(c *gin.Context) {
+	suggestions, err := func(c *gin.Context) (suggestions []models.Suggestion, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		suggestions, err = api.GetSuggestions(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("got %d suggestions", len(suggestions)), "success": true, "suggestions": suggestions})
+	}
+}
+
+func handlerCalibrationLint(c *gin.Context) {
+	report, err := func(c *gin.Context) (report models.LintReport, err error) {
+		_, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		report, err = api.LintFamily(d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("found %d errors and %d warnings", report.Errors, report.Warnings), "success": true, "lint": report})
+	}
+}
+
+func handlerBeacons(c *gin.Context) {
+	beacons, err := func(c *gin.Context) (beacons models.BeaconInventory, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		beacons, err = api.GetBeacons(family, c.DefaultQuery("type", ""), c.DefaultQuery("rebuild", "0") == "1", d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("got %d beacons", len(beacons)), "success": true, "beacons": beacons})
+	}
+}
+
+func handlerDrift(c *gin.Context) {
+	report, err := func(c *gin.Context) (report models.DriftReport, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		report, err = api.GetDriftReport(family, d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("drift score is %2.2f", report.Score), "success": true, "drift": report})
+	}
+}
+
+func handlerShadow(c *gin.Context) {
+	report, err := func(c *gin.Context) (report models.ShadowReport, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+
+		switch c.Request.Method {
+		case "POST":
+			var settings models.CandidateSettings
+			if err = c.BindJSON(&settings); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			report, err = api.StartShadow(family, settings, d)
+		case "DELETE":
+			report, err = api.StopShadow(family, d)
+		default:
+			report, err = api.GetShadowReport(family, d)
+		}
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("candidate %s is %s", report.ID, report.Status), "success": true, "shadow": report})
+	}
+}
+
+func handlerLearningSession(c *gin.Context) {
+	session, err := func(c *gin.Context) (session models.LearningSession, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		device := strings.ToLower(strings.TrimSpace(c.Param("device")))
+
+		switch c.Request.Method {
+		case "POST":
+			type LearningSettings struct {
+				Location string `json:"location" binding:"required"`
+				// Target is the number of fingerprints to collect
+				Target int `json:"target" binding:"required"`
+				// Timeout is the number of seconds before the session stops
+				Timeout int64 `json:"timeout"`
+			}
+			var settings LearningSettings
+			if err = c.BindJSON(&settings); err != nil {
+				err = errors.Wrap(err, "problem binding data")
+				return
+			}
+			location := strings.ToLower(strings.TrimSpace(settings.Location))
+			session, err = api.StartLearningSession(family, device, location, settings.Target, time.Duration(settings.Timeout)*time.Second, d)
+		case "DELETE":
+			session, err = api.StopLearningSession(family, device, d)
+		default:
+			session, err = api.GetLearningSession(family, device, d)
+		}
+		return
+	}(c)
 	if err != nil {
-		message = err.Error()
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("learned %d/%d fingerprints of %s at %s", session.Collected, session.Target, session.Device, session.Location), "success": true, "learning": session, "progress": session.Progress()})
+	}
+}
+
+func handlerShadowPromote(c *gin.Context) {
+	report, err := func(c *gin.Context) (report models.ShadowReport, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		report, err = api.PromoteShadow(family, d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("promoted candidate %s", report.ID), "success": true, "shadow": report})
+	}
+}
+
+func handlerCalibrationStatus(c *gin.Context) {
+	type Status struct {
+		Policy  models.CalibrationPolicy `json:"policy"`
+		State   models.CalibrationState  `json:"state"`
+		Running bool                     `json:"running"`
+	}
+	status, err := func(c *gin.Context) (status Status, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		status.Policy = api.GetCalibrationPolicy(d)
+		status.State = api.GetCalibrationState(d)
+		status.Running = api.IsCalibrating(family)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "got calibration status", "success": true, "status": status})
+	}
+}
+
+func handlerCalibrationPolicy(c *gin.Context) {
+	policy, err := func(c *gin.Context) (policy models.CalibrationPolicy, err error) {
+		family, d, err := familyDatabase(c)
+		if err != nil {
+			return
+		}
+		defer d.Close()
+		policy = api.DefaultCalibrationPolicy
+		if err = c.BindJSON(&policy); err != nil {
+			err = errors.Wrap(err, "problem binding data")
+			return
+		}
+		err = api.SetCalibrationPolicy(family, policy, d)
+		return
+	}(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+	} else {
+		c.JSON(http.StatusOK, gin.H{"message": "set calibration policy", "success": true, "policy": policy})
 	}
-	c.JSON(http.StatusOK, gin.H{"message": message, "success": err == nil})
 }
 
 func handlerMQTT(c *gin.Context) {
@@ -424,6 +1019,16 @@ func handlerNow(c *gin.Context) {
 	c.String(200, strconv.Itoa(int(time.Now().UTC().UnixNano()/int64(time.Millisecond))))
 }
 
+// handlerHealth reports whether the AI servers can be used, responding 503 when none can
+func handlerHealth(c *gin.Context) {
+	workers, err := api.AIHealth()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error(), "success": false, "workers": workers, "cache": api.GetCacheStats("")})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok", "success": true, "workers": workers, "cache": api.GetCacheStats("")})
+}
+
 func handlerLearn(c *gin.Context) {
 	message, err := func(c *gin.Context) (message string, err error) {
 		//justSave := c.DefaultQuery("justsave", "0") == "1"
@@ -440,6 +1045,10 @@ func handlerLearn(c *gin.Context) {
 			return
 		}
 
+		go api.CountLearningSample(s.Family)
+		go api.UpdateBeacons(s)
+		api.ObserveShadowLearning(s)
+
 		// success
 		message = "inserted data"
 		logger.Log.Debugf("[%s] /data %+v", s.Family, s)
@@ -674,7 +1283,9 @@ func parseRollingData(family string) (err error) {
 		err := db.StoreSensorData(sensorMap[sensor])
 		if err != nil {
 			logger.Log.Warnf("[%s] problem saving: %s", family, err.Error())
+			continue
 		}
+		go api.UpdateBeacons(sensorMap[sensor])
 		logger.Log.Debugf("[%s] saved reverse sensor data for %s", family, sensor)
 	}
 
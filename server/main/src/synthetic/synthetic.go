@@ -0,0 +1,354 @@
+// Package synthetic generates realistic fingerprints from a floor plan, for
+// tests and demos that cannot use recorded data.
+package synthetic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/schollz/find3/server/main/src/models"
+)
+
+// FloorPlan describes the rooms and beacons of a building
+type FloorPlan struct {
+	Family  string   `json:"family"`
+	Rooms   []Room   `json:"rooms"`
+	Beacons []Beacon `json:"beacons"`
+	Walls   []Wall   `json:"walls"`
+	// PathLossExponent is how fast the signal weakens with distance, 2 in free space
+	PathLossExponent float64 `json:"path_loss_exponent"`
+	// FloorAttenuation is the loss in dB for each floor between a beacon and a room
+	FloorAttenuation float64 `json:"floor_attenuation"`
+	// Noise is the standard deviation of the signal strength in dB, where nil
+	// is the default and 0 is no noise
+	Noise *float64 `json:"noise,omitempty"`
+	// Dropout is the probability that a beacon is missed by a scan, where nil
+	// is the default and 0 is never
+	Dropout *float64 `json:"dropout,omitempty"`
+	// Sensitivity is the weakest signal strength that is still detected
+	Sensitivity float64 `json:"sensitivity"`
+}
+
+// Room is a rectangle where fingerprints are taken, in metres
+type Room struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+	Floor  int     `json:"floor"`
+}
+
+// Beacon is a wifi access point or bluetooth beacon
+type Beacon struct {
+	ID string `json:"id"`
+	// Type is the sensor type, "wifi" or "bluetooth"
+	Type  string  `json:"type"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Floor int     `json:"floor"`
+	// Power is the signal strength at 1 metre
+	Power float64 `json:"power"`
+}
+
+// Wall weakens the signals that cross it
+type Wall struct {
+	X1          float64 `json:"x1"`
+	Y1          float64 `json:"y1"`
+	X2          float64 `json:"x2"`
+	Y2          float64 `json:"y2"`
+	Attenuation float64 `json:"attenuation"`
+}
+
+// Options decide how much data is generated
+type Options struct {
+	// Seed makes the output reproducible
+	Seed int64
+	// LearnPerRoom is the number of learning fingerprints of each room and device
+	LearnPerRoom int
+	// Track is the number of tracking fingerprints of each device
+	Track int
+	// Devices take the fingerprints
+	Devices []string
+	// Start is the time of the first fingerprint
+	Start time.Time
+	// Interval is the time between the fingerprints of a device
+	Interval time.Duration
+	// LabelTrack sets the true room of the tracking fingerprints, for evaluating
+	LabelTrack bool
+}
+
+// DefaultFloorPlan has the values used for the settings a floor plan leaves out
+var DefaultFloorPlan = FloorPlan{
+	Family:           "synthetic",
+	PathLossExponent: 3,
+	FloorAttenuation: 15,
+	Noise:            float64Pointer(4),
+	Dropout:          float64Pointer(0.1),
+	Sensitivity:      -95,
+}
+
+// DefaultOptions are used for the options that are left out
+var DefaultOptions = Options{
+	Seed:         1,
+	LearnPerRoom: 20,
+	Track:        100,
+	Devices:      []string{"device1"},
+	Start:        time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	Interval:     5 * time.Second,
+}
+
+// roomStay is the mean number of tracking fingerprints before moving to another room
+const roomStay = 10
+
+// Validate will validate that the floor plan is okay, and fill in the
+// defaults. The rooms, beacons and walls are copied, so that the slices of
+// the caller are not changed.
+func (p *FloorPlan) Validate() (err error) {
+	p.Rooms = append([]Room(nil), p.Rooms...)
+	p.Beacons = append([]Beacon(nil), p.Beacons...)
+	p.Walls = append([]Wall(nil), p.Walls...)
+	if len(p.Rooms) == 0 {
+		return errors.New("floor plan has no rooms")
+	}
+	if len(p.Beacons) == 0 {
+		return errors.New("floor plan has no beacons")
+	}
+	for _, room := range p.Rooms {
+		if room.Name == "" {
+			return errors.New("room needs a name")
+		}
+		if room.Width < 0 || room.Height < 0 {
+			return fmt.Errorf("room '%s' has a negative size", room.Name)
+		}
+	}
+	for i, beacon := range p.Beacons {
+		if beacon.ID == "" {
+			return errors.New("beacon needs an id")
+		}
+		if beacon.Type == "" {
+			p.Beacons[i].Type = "wifi"
+		}
+		if beacon.Power == 0 {
+			p.Beacons[i].Power = -40
+		}
+	}
+	if p.Family == "" {
+		p.Family = DefaultFloorPlan.Family
+	}
+	if p.PathLossExponent <= 0 {
+		p.PathLossExponent = DefaultFloorPlan.PathLossExponent
+	}
+	if p.FloorAttenuation <= 0 {
+		p.FloorAttenuation = DefaultFloorPlan.FloorAttenuation
+	}
+	if p.Noise == nil {
+		p.Noise = float64Pointer(*DefaultFloorPlan.Noise)
+	} else if *p.Noise < 0 {
+		return errors.New("noise cannot be negative")
+	}
+	if p.Dropout == nil {
+		p.Dropout = float64Pointer(*DefaultFloorPlan.Dropout)
+	} else if *p.Dropout < 0 || *p.Dropout >= 1 {
+		return errors.New("dropout must be at least 0 and less than 1")
+	}
+	if p.Sensitivity == 0 {
+		p.Sensitivity = DefaultFloorPlan.Sensitivity
+	}
+	return
+}
+
+// Generator simulates the fingerprints of a floor plan
+type Generator struct {
+	plan FloorPlan
+	opts Options
+	r    *rand.Rand
+}
+
+// New returns a generator for the floor plan
+func New(plan FloorPlan, opts Options) (g *Generator, err error) {
+	if err = plan.Validate(); err != nil {
+		return
+	}
+	if opts.LearnPerRoom <= 0 {
+		opts.LearnPerRoom = DefaultOptions.LearnPerRoom
+	}
+	if opts.Track <= 0 {
+		opts.Track = DefaultOptions.Track
+	}
+	if len(opts.Devices) == 0 {
+		opts.Devices = DefaultOptions.Devices
+	}
+	if opts.Start.IsZero() {
+		opts.Start = DefaultOptions.Start
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultOptions.Interval
+	}
+	g = &Generator{plan: plan, opts: opts, r: rand.New(rand.NewSource(opts.Seed))}
+	return
+}
+
+// Learn generates the learning fingerprints of every room and device
+func (g *Generator) Learn() (datas []models.SensorData) {
+	t := g.opts.Start
+	for _, device := range g.opts.Devices {
+		for _, room := range g.plan.Rooms {
+			for i := 0; i < g.opts.LearnPerRoom; i++ {
+				s := g.Fingerprint(room, device, t)
+				s.Location = room.Name
+				datas = append(datas, s)
+				t = t.Add(g.opts.Interval)
+			}
+		}
+	}
+	return
+}
+
+// Track generates the tracking fingerprints of each device walking between
+// random rooms, starting after the learning fingerprints
+func (g *Generator) Track() (datas []models.SensorData) {
+	learnTime := time.Duration(len(g.opts.Devices)*len(g.plan.Rooms)*g.opts.LearnPerRoom) * g.opts.Interval
+	for d, device := range g.opts.Devices {
+		// timestamps identify fingerprints, so they must differ between devices
+		t := g.opts.Start.Add(learnTime + time.Duration(d)*time.Millisecond)
+		room := g.plan.Rooms[g.r.Intn(len(g.plan.Rooms))]
+		for i := 0; i < g.opts.Track; i++ {
+			if g.r.Float64() < 1.0/roomStay {
+				room = g.plan.Rooms[g.r.Intn(len(g.plan.Rooms))]
+			}
+			s := g.Fingerprint(room, device, t)
+			if g.opts.LabelTrack {
+				s.Location = room.Name
+			}
+			datas = append(datas, s)
+			t = t.Add(g.opts.Interval)
+		}
+	}
+	return
+}
+
+// Fingerprint simulates a scan of a device at a random point in the room
+func (g *Generator) Fingerprint(room Room, device string, t time.Time) (s models.SensorData) {
+	x := room.X + g.r.Float64()*room.Width
+	y := room.Y + g.r.Float64()*room.Height
+	s = models.SensorData{
+		Timestamp: t.UnixNano() / int64(time.Millisecond),
+		Family:    g.plan.Family,
+		Device:    device,
+		Sensors:   make(map[string]map[string]interface{}),
+	}
+	var strongest Beacon
+	strongestRSSI := math.Inf(-1)
+	for _, beacon := range g.plan.Beacons {
+		rssi := g.RSSI(beacon, x, y, room.Floor) + g.r.NormFloat64()*(*g.plan.Noise)
+		if rssi > strongestRSSI {
+			strongest, strongestRSSI = beacon, rssi
+		}
+		if g.r.Float64() < *g.plan.Dropout || rssi < g.plan.Sensitivity {
+			continue
+		}
+		g.add(&s, beacon, rssi)
+	}
+	// a scan that found nothing would not be sent
+	if len(s.Sensors) == 0 {
+		g.add(&s, strongest, strongestRSSI)
+	}
+	return
+}
+
+func (g *Generator) add(s *models.SensorData, beacon Beacon, rssi float64) {
+	if _, ok := s.Sensors[beacon.Type]; !ok {
+		s.Sensors[beacon.Type] = make(map[string]interface{})
+	}
+	s.Sensors[beacon.Type][beacon.ID] = math.Round(rssi)
+}
+
+// RSSI returns the signal strength of the beacon at a point without noise,
+// from the log-distance path loss and the walls and floors in between
+func (g *Generator) RSSI(beacon Beacon, x, y float64, floor int) float64 {
+	d := math.Max(math.Hypot(x-beacon.X, y-beacon.Y), 1)
+	rssi := beacon.Power - 10*g.plan.PathLossExponent*math.Log10(d)
+	for _, wall := range g.plan.Walls {
+		if intersects(beacon.X, beacon.Y, x, y, wall.X1, wall.Y1, wall.X2, wall.Y2) {
+			rssi -= wall.Attenuation
+		}
+	}
+	floors := floor - beacon.Floor
+	if floors < 0 {
+		floors = -floors
+	}
+	return rssi - float64(floors)*g.plan.FloorAttenuation
+}
+
+// intersects returns whether the segments (x1,y1)-(x2,y2) and (x3,y3)-(x4,y4) cross
+func intersects(x1, y1, x2, y2, x3, y3, x4, y4 float64) bool {
+	d1 := cross(x3, y3, x4, y4, x1, y1)
+	d2 := cross(x3, y3, x4, y4, x2, y2)
+	d3 := cross(x1, y1, x2, y2, x3, y3)
+	d4 := cross(x1, y1, x2, y2, x4, y4)
+	return ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0))
+}
+
+func cross(ax, ay, bx, by, cx, cy float64) float64 {
+	return (bx-ax)*(cy-ay) - (by-ay)*(cx-ax)
+}
+
+// WriteNDJSON writes the sensor data as one JSON object per line, like api.Dump
+func WriteNDJSON(w io.Writer, datas []models.SensorData) (err error) {
+	enc := json.NewEncoder(w)
+	for _, data := range datas {
+		if err = enc.Encode(data); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GenerateFiles reads a floor plan from a JSON file and writes its learning
+// and tracking fingerprints next to it, named like the files of api.Dump
+func GenerateFiles(planFile string, opts Options) (learnFile string, trackFile string, err error) {
+	b, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		return
+	}
+	var plan FloorPlan
+	if err = json.Unmarshal(b, &plan); err != nil {
+		return
+	}
+	g, err := New(plan, opts)
+	if err != nil {
+		return
+	}
+	learn := g.Learn()
+	track := g.Track()
+	dir := filepath.Dir(planFile)
+	learnFile = filepath.Join(dir, fmt.Sprintf("%s.learn.%d.jsons", g.plan.Family, learn[len(learn)-1].Timestamp))
+	if err = writeFile(learnFile, learn); err != nil {
+		return
+	}
+	trackFile = filepath.Join(dir, fmt.Sprintf("%s.track.%d.jsons", g.plan.Family, track[len(track)-1].Timestamp))
+	err = writeFile(trackFile, track)
+	return
+}
+
+func writeFile(fname string, datas []models.SensorData) (err error) {
+	f, err := os.Create(fname)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return WriteNDJSON(f, datas)
+}
+
+func float64Pointer(f float64) *float64 {
+	return &f
+}
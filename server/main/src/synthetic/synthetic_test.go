@@ -0,0 +1,86 @@
+package synthetic
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testPlan = FloorPlan{
+	Rooms: []Room{
+		{Name: "kitchen", X: 0, Y: 0, Width: 4, Height: 4},
+		{Name: "living", X: 6, Y: 0, Width: 4, Height: 4},
+		{Name: "bedroom", X: 0, Y: 0, Width: 4, Height: 4, Floor: 1},
+	},
+	Beacons: []Beacon{
+		{ID: "aa:00:00:00:00:01", X: 2, Y: 2},
+		{ID: "aa:00:00:00:00:02", X: 8, Y: 2},
+		{ID: "bb:00:00:00:00:03", Type: "bluetooth", X: 2, Y: 2, Floor: 1},
+	},
+	Walls: []Wall{{X1: 5, Y1: -10, X2: 5, Y2: 10, Attenuation: 10}},
+}
+
+func TestGenerate(t *testing.T) {
+	g, err := New(testPlan, Options{Seed: 7, Devices: []string{"phone1", "phone2"}, Track: 50})
+	assert.Nil(t, err)
+	learn := g.Learn()
+	track := g.Track()
+	assert.Equal(t, 2*3*20, len(learn))
+	assert.Equal(t, 2*50, len(track))
+	assert.Equal(t, "kitchen", learn[0].Location)
+	assert.Equal(t, "", track[0].Location)
+	assert.Equal(t, "synthetic", learn[0].Family)
+	assert.True(t, track[0].Timestamp > learn[len(learn)-1].Timestamp)
+
+	// the same seed gives the same data
+	var b1, b2 bytes.Buffer
+	assert.Nil(t, WriteNDJSON(&b1, learn))
+	g, _ = New(testPlan, Options{Seed: 7, Devices: []string{"phone1", "phone2"}, Track: 50})
+	assert.Nil(t, WriteNDJSON(&b2, g.Learn()))
+	assert.Equal(t, b1.String(), b2.String())
+	assert.Equal(t, 2*3*20, bytes.Count(b1.Bytes(), []byte("\n")))
+}
+
+func TestRSSI(t *testing.T) {
+	g, err := New(testPlan, Options{})
+	assert.Nil(t, err)
+	beacon := g.plan.Beacons[0]
+	assert.Equal(t, -40.0, g.RSSI(beacon, 2, 2, 0))
+	// log-distance path loss, 30 dB per decade
+	assert.InDelta(t, -70, g.RSSI(beacon, 2, 12, 0), 0.001)
+	// the wall and the floor weaken the signal
+	assert.InDelta(t, -70-10, g.RSSI(beacon, 12, 2, 0), 0.001)
+	assert.InDelta(t, -40-15, g.RSSI(beacon, 2, 2, 1), 0.001)
+}
+
+func TestValidateNoiseDropout(t *testing.T) {
+	plan := testPlan
+	assert.Nil(t, plan.Validate())
+	// the defaults are not written into the shared beacons
+	assert.Equal(t, "", testPlan.Beacons[0].Type)
+	assert.Equal(t, 0.0, testPlan.Beacons[0].Power)
+	assert.Equal(t, "wifi", plan.Beacons[0].Type)
+	assert.Equal(t, 4.0, *plan.Noise)
+	assert.Equal(t, 0.1, *plan.Dropout)
+
+	// zero is kept, only nil is the default
+	plan = testPlan
+	plan.Rooms = []Room{{Name: "kitchen", X: 2, Y: 2}}
+	plan.Noise = float64Pointer(0)
+	plan.Dropout = float64Pointer(0)
+	g, err := New(plan, Options{Seed: 3, LearnPerRoom: 5})
+	assert.Nil(t, err)
+	learn := g.Learn()
+	assert.Equal(t, 3, len(learn[0].Sensors["wifi"])+len(learn[0].Sensors["bluetooth"]))
+	for i := range learn {
+		assert.Equal(t, learn[0].Sensors, learn[i].Sensors)
+	}
+
+	plan = testPlan
+	plan.Noise = float64Pointer(-1)
+	assert.NotNil(t, plan.Validate())
+	plan = testPlan
+	plan.Dropout = float64Pointer(1)
+	assert.NotNil(t, plan.Validate())
+}